@@ -282,17 +282,7 @@ func GetDecompressorAsm() string {
 }
 
 func opcodeSize(op byte) int {
-	// Implied/accumulator
-	if op == 0x00 || op == 0x08 || op == 0x18 || op == 0x28 || op == 0x38 || op == 0x48 || op == 0x68 ||
-		op == 0x8A || op == 0x98 || op == 0xAA || op == 0xA8 || op == 0xCA || op == 0xE8 || op == 0x0A || op == 0x4A || op == 0x2A || op == 0x6A || op == 0x60 {
-		return 1
-	}
-	// Absolute (JMP, JSR, BIT abs)
-	if op == 0x20 || op == 0x4C || op == 0x2C {
-		return 3
-	}
-	// Everything else is 2 bytes
-	return 2
+	return instrSize(op)
 }
 
 func isBranch(op byte) bool {
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+)
+
+// addrMode is a 6502 addressing mode, which determines operand size and syntax
+type addrMode byte
+
+const (
+	modeNone addrMode = iota // not implemented by CPU6502
+	modeImp                  // implied
+	modeAcc                  // accumulator
+	modeImm                  // #$nn
+	modeZP                   // $nn
+	modeZPX                  // $nn,X
+	modeZPY                  // $nn,Y
+	modeAbs                  // $nnnn
+	modeAbsX                 // $nnnn,X
+	modeAbsY                 // $nnnn,Y
+	modeInd                  // ($nnnn)
+	modeIndX                 // ($nn,X)
+	modeIndY                 // ($nn),Y
+	modeRel                  // branch target
+)
+
+// modeSize is the instruction length in bytes for each addressing mode
+var modeSize = [...]int{
+	modeNone: 1, modeImp: 1, modeAcc: 1,
+	modeImm: 2, modeZP: 2, modeZPX: 2, modeZPY: 2, modeIndX: 2, modeIndY: 2, modeRel: 2,
+	modeAbs: 3, modeAbsX: 3, modeAbsY: 3, modeInd: 3,
+}
+
+type opcodeInfo struct {
+	name string
+	mode addrMode
+}
+
+// opcodeTable lists every opcode implemented by CPU6502.Step,
+// including the stable undocumented ones
+var opcodeTable = [256]opcodeInfo{
+	0x00: {"BRK", modeImp}, 0x01: {"ORA", modeIndX}, 0x03: {"SLO", modeIndX},
+	0x05: {"ORA", modeZP}, 0x06: {"ASL", modeZP}, 0x07: {"SLO", modeZP},
+	0x08: {"PHP", modeImp}, 0x09: {"ORA", modeImm}, 0x0A: {"ASL", modeAcc},
+	0x0D: {"ORA", modeAbs}, 0x0E: {"ASL", modeAbs}, 0x0F: {"SLO", modeAbs},
+
+	0x10: {"BPL", modeRel}, 0x11: {"ORA", modeIndY}, 0x13: {"SLO", modeIndY},
+	0x15: {"ORA", modeZPX}, 0x16: {"ASL", modeZPX}, 0x17: {"SLO", modeZPX},
+	0x18: {"CLC", modeImp}, 0x19: {"ORA", modeAbsY}, 0x1B: {"SLO", modeAbsY},
+	0x1D: {"ORA", modeAbsX}, 0x1E: {"ASL", modeAbsX}, 0x1F: {"SLO", modeAbsX},
+
+	0x20: {"JSR", modeAbs}, 0x21: {"AND", modeIndX}, 0x23: {"RLA", modeIndX},
+	0x24: {"BIT", modeZP}, 0x25: {"AND", modeZP}, 0x26: {"ROL", modeZP}, 0x27: {"RLA", modeZP},
+	0x28: {"PLP", modeImp}, 0x29: {"AND", modeImm}, 0x2A: {"ROL", modeAcc},
+	0x2C: {"BIT", modeAbs}, 0x2D: {"AND", modeAbs}, 0x2E: {"ROL", modeAbs}, 0x2F: {"RLA", modeAbs},
+
+	0x30: {"BMI", modeRel}, 0x31: {"AND", modeIndY}, 0x33: {"RLA", modeIndY},
+	0x35: {"AND", modeZPX}, 0x36: {"ROL", modeZPX}, 0x37: {"RLA", modeZPX},
+	0x38: {"SEC", modeImp}, 0x39: {"AND", modeAbsY}, 0x3B: {"RLA", modeAbsY},
+	0x3D: {"AND", modeAbsX}, 0x3E: {"ROL", modeAbsX}, 0x3F: {"RLA", modeAbsX},
+
+	0x40: {"RTI", modeImp}, 0x41: {"EOR", modeIndX}, 0x43: {"SRE", modeIndX},
+	0x45: {"EOR", modeZP}, 0x46: {"LSR", modeZP}, 0x47: {"SRE", modeZP},
+	0x48: {"PHA", modeImp}, 0x49: {"EOR", modeImm}, 0x4A: {"LSR", modeAcc},
+	0x4C: {"JMP", modeAbs}, 0x4D: {"EOR", modeAbs}, 0x4E: {"LSR", modeAbs}, 0x4F: {"SRE", modeAbs},
+
+	0x50: {"BVC", modeRel}, 0x51: {"EOR", modeIndY}, 0x53: {"SRE", modeIndY},
+	0x55: {"EOR", modeZPX}, 0x56: {"LSR", modeZPX}, 0x57: {"SRE", modeZPX},
+	0x58: {"CLI", modeImp}, 0x59: {"EOR", modeAbsY}, 0x5B: {"SRE", modeAbsY},
+	0x5D: {"EOR", modeAbsX}, 0x5E: {"LSR", modeAbsX}, 0x5F: {"SRE", modeAbsX},
+
+	0x60: {"RTS", modeImp}, 0x61: {"ADC", modeIndX}, 0x63: {"RRA", modeIndX},
+	0x65: {"ADC", modeZP}, 0x66: {"ROR", modeZP}, 0x67: {"RRA", modeZP},
+	0x68: {"PLA", modeImp}, 0x69: {"ADC", modeImm}, 0x6A: {"ROR", modeAcc},
+	0x6C: {"JMP", modeInd}, 0x6D: {"ADC", modeAbs}, 0x6E: {"ROR", modeAbs}, 0x6F: {"RRA", modeAbs},
+
+	0x70: {"BVS", modeRel}, 0x71: {"ADC", modeIndY}, 0x73: {"RRA", modeIndY},
+	0x75: {"ADC", modeZPX}, 0x76: {"ROR", modeZPX}, 0x77: {"RRA", modeZPX},
+	0x78: {"SEI", modeImp}, 0x79: {"ADC", modeAbsY}, 0x7B: {"RRA", modeAbsY},
+	0x7D: {"ADC", modeAbsX}, 0x7E: {"ROR", modeAbsX}, 0x7F: {"RRA", modeAbsX},
+
+	0x81: {"STA", modeIndX}, 0x83: {"SAX", modeIndX},
+	0x84: {"STY", modeZP}, 0x85: {"STA", modeZP}, 0x86: {"STX", modeZP}, 0x87: {"SAX", modeZP},
+	0x88: {"DEY", modeImp}, 0x8A: {"TXA", modeImp},
+	0x8C: {"STY", modeAbs}, 0x8D: {"STA", modeAbs}, 0x8E: {"STX", modeAbs}, 0x8F: {"SAX", modeAbs},
+
+	0x90: {"BCC", modeRel}, 0x91: {"STA", modeIndY},
+	0x94: {"STY", modeZPX}, 0x95: {"STA", modeZPX}, 0x96: {"STX", modeZPY}, 0x97: {"SAX", modeZPY},
+	0x98: {"TYA", modeImp}, 0x99: {"STA", modeAbsY}, 0x9A: {"TXS", modeImp},
+	0x9D: {"STA", modeAbsX},
+
+	0xA0: {"LDY", modeImm}, 0xA1: {"LDA", modeIndX}, 0xA2: {"LDX", modeImm}, 0xA3: {"LAX", modeIndX},
+	0xA4: {"LDY", modeZP}, 0xA5: {"LDA", modeZP}, 0xA6: {"LDX", modeZP}, 0xA7: {"LAX", modeZP},
+	0xA8: {"TAY", modeImp}, 0xA9: {"LDA", modeImm}, 0xAA: {"TAX", modeImp},
+	0xAC: {"LDY", modeAbs}, 0xAD: {"LDA", modeAbs}, 0xAE: {"LDX", modeAbs}, 0xAF: {"LAX", modeAbs},
+
+	0xB0: {"BCS", modeRel}, 0xB1: {"LDA", modeIndY}, 0xB3: {"LAX", modeIndY},
+	0xB4: {"LDY", modeZPX}, 0xB5: {"LDA", modeZPX}, 0xB6: {"LDX", modeZPY}, 0xB7: {"LAX", modeZPY},
+	0xB8: {"CLV", modeImp}, 0xB9: {"LDA", modeAbsY}, 0xBA: {"TSX", modeImp},
+	0xBC: {"LDY", modeAbsX}, 0xBD: {"LDA", modeAbsX}, 0xBE: {"LDX", modeAbsY}, 0xBF: {"LAX", modeAbsY},
+
+	0xC0: {"CPY", modeImm}, 0xC1: {"CMP", modeIndX}, 0xC3: {"DCP", modeIndX},
+	0xC4: {"CPY", modeZP}, 0xC5: {"CMP", modeZP}, 0xC6: {"DEC", modeZP}, 0xC7: {"DCP", modeZP},
+	0xC8: {"INY", modeImp}, 0xC9: {"CMP", modeImm}, 0xCA: {"DEX", modeImp},
+	0xCC: {"CPY", modeAbs}, 0xCD: {"CMP", modeAbs}, 0xCE: {"DEC", modeAbs}, 0xCF: {"DCP", modeAbs},
+
+	0xD0: {"BNE", modeRel}, 0xD1: {"CMP", modeIndY}, 0xD3: {"DCP", modeIndY},
+	0xD5: {"CMP", modeZPX}, 0xD6: {"DEC", modeZPX}, 0xD7: {"DCP", modeZPX},
+	0xD8: {"CLD", modeImp}, 0xD9: {"CMP", modeAbsY}, 0xDB: {"DCP", modeAbsY},
+	0xDD: {"CMP", modeAbsX}, 0xDE: {"DEC", modeAbsX}, 0xDF: {"DCP", modeAbsX},
+
+	0xE0: {"CPX", modeImm}, 0xE1: {"SBC", modeIndX}, 0xE3: {"ISC", modeIndX},
+	0xE4: {"CPX", modeZP}, 0xE5: {"SBC", modeZP}, 0xE6: {"INC", modeZP}, 0xE7: {"ISC", modeZP},
+	0xE8: {"INX", modeImp}, 0xE9: {"SBC", modeImm}, 0xEA: {"NOP", modeImp},
+	0xEC: {"CPX", modeAbs}, 0xED: {"SBC", modeAbs}, 0xEE: {"INC", modeAbs}, 0xEF: {"ISC", modeAbs},
+
+	0xF0: {"BEQ", modeRel}, 0xF1: {"SBC", modeIndY}, 0xF3: {"ISC", modeIndY},
+	0xF5: {"SBC", modeZPX}, 0xF6: {"INC", modeZPX}, 0xF7: {"ISC", modeZPX},
+	0xF8: {"SED", modeImp}, 0xF9: {"SBC", modeAbsY}, 0xFB: {"ISC", modeAbsY},
+	0xFD: {"SBC", modeAbsX}, 0xFE: {"INC", modeAbsX}, 0xFF: {"ISC", modeAbsX},
+}
+
+// instrSize returns the length in bytes of the instruction starting with op.
+// Unimplemented opcodes count as 1 byte.
+func instrSize(op byte) int {
+	return modeSize[opcodeTable[op].mode]
+}
+
+// Disassemble decodes the instruction at addr, where data holds memory
+// starting at base. Returns the text (e.g. "LDA $1000,X") and its length.
+// Unimplemented or truncated instructions come back as a single .byte.
+func Disassemble(data []byte, base uint16, addr uint16) (string, int) {
+	i := int(addr) - int(base)
+	if i < 0 || i >= len(data) {
+		return "", 0
+	}
+	op := data[i]
+	info := opcodeTable[op]
+	size := modeSize[info.mode]
+	if info.mode == modeNone || i+size > len(data) {
+		return fmt.Sprintf(".byte $%02X", op), 1
+	}
+
+	var b1, w uint16
+	if size >= 2 {
+		b1 = uint16(data[i+1])
+		w = b1
+	}
+	if size == 3 {
+		w |= uint16(data[i+2]) << 8
+	}
+
+	var operand string
+	switch info.mode {
+	case modeImp:
+		return info.name, size
+	case modeAcc:
+		operand = "A"
+	case modeImm:
+		operand = fmt.Sprintf("#$%02X", b1)
+	case modeZP:
+		operand = fmt.Sprintf("$%02X", b1)
+	case modeZPX:
+		operand = fmt.Sprintf("$%02X,X", b1)
+	case modeZPY:
+		operand = fmt.Sprintf("$%02X,Y", b1)
+	case modeAbs:
+		operand = fmt.Sprintf("$%04X", w)
+	case modeAbsX:
+		operand = fmt.Sprintf("$%04X,X", w)
+	case modeAbsY:
+		operand = fmt.Sprintf("$%04X,Y", w)
+	case modeInd:
+		operand = fmt.Sprintf("($%04X)", w)
+	case modeIndX:
+		operand = fmt.Sprintf("($%02X,X)", b1)
+	case modeIndY:
+		operand = fmt.Sprintf("($%02X),Y", b1)
+	case modeRel:
+		target := addr + 2 + uint16(int8(b1))
+		operand = fmt.Sprintf("$%04X", target)
+	}
+	return info.name + " " + operand, size
+}
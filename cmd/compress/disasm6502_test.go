@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	tests := []struct {
+		code     []byte
+		want     string
+		wantSize int
+	}{
+		{[]byte{0x60}, "RTS", 1},                     // modeImp
+		{[]byte{0x0A}, "ASL A", 1},                   // modeAcc
+		{[]byte{0xA9, 0x80}, "LDA #$80", 2},          // modeImm
+		{[]byte{0xA5, 0x02}, "LDA $02", 2},           // modeZP
+		{[]byte{0xB5, 0x02}, "LDA $02,X", 2},         // modeZPX
+		{[]byte{0xB6, 0x02}, "LDX $02,Y", 2},         // modeZPY
+		{[]byte{0xAD, 0x00, 0x10}, "LDA $1000", 3},   // modeAbs
+		{[]byte{0xBD, 0x00, 0x10}, "LDA $1000,X", 3}, // modeAbsX
+		{[]byte{0xB9, 0x00, 0x10}, "LDA $1000,Y", 3}, // modeAbsY
+		{[]byte{0x6C, 0x34, 0x12}, "JMP ($1234)", 3}, // modeInd
+		{[]byte{0xA1, 0x20}, "LDA ($20,X)", 2},       // modeIndX
+		{[]byte{0xB1, 0x09}, "LDA ($09),Y", 2},       // modeIndY
+		{[]byte{0xD0, 0x10}, "BNE $0D12", 2},         // modeRel forward
+		{[]byte{0xF0, 0xFE}, "BEQ $0D00", 2},         // modeRel to itself
+		{[]byte{0x90, 0x80}, "BCC $0C82", 2},         // modeRel backward, max
+		{[]byte{0xB3, 0x20}, "LAX ($20),Y", 2},       // undocumented
+		{[]byte{0x02}, ".byte $02", 1},               // modeNone (JAM)
+		{[]byte{0xAD, 0x00}, ".byte $AD", 1},         // truncated abs
+		{[]byte{0xD0}, ".byte $D0", 1},               // truncated branch
+	}
+	for _, tt := range tests {
+		got, size := Disassemble(tt.code, 0x0D00, 0x0D00)
+		if got != tt.want || size != tt.wantSize {
+			t.Errorf("% X: got %q (%d), want %q (%d)", tt.code, got, size, tt.want, tt.wantSize)
+		}
+	}
+
+	// Addresses outside data
+	if got, size := Disassemble([]byte{0xEA}, 0x0D00, 0x0CFF); got != "" || size != 0 {
+		t.Errorf("below base: got %q (%d)", got, size)
+	}
+	if got, size := Disassemble([]byte{0xEA}, 0x0D00, 0x0D01); got != "" || size != 0 {
+		t.Errorf("past end: got %q (%d)", got, size)
+	}
+}
+
+// TestDecompressorAsmGolden locks the generated listing, which walks the
+// code with opcodeSize, to the committed generated/decompress.asm
+func TestDecompressorAsmGolden(t *testing.T) {
+	want, err := os.ReadFile(filepath.Join("..", "..", "generated", "decompress.asm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "decompress.asm")
+	if err := WriteDecompressorAsm(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("WriteDecompressorAsm output differs from generated/decompress.asm")
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"math/bits"
+	"sort"
 )
 
 // CPU6502 is a minimal 6502 emulator for testing decompression
@@ -11,12 +13,16 @@ type CPU6502 struct {
 	PC      uint16 // Program counter
 	P       byte   // Status flags: NV-BDIZC
 
-	Mem    [65536]byte
+	mem    [65536]byte // Written only via write/push/Poke/LoadAt so Restore can track it
 	Cycles uint64
 
-	// Breakpoint for stopping execution
-	Breakpoint uint16
-	Halted     bool
+	// Breakpoint for stopping execution. The first Step after a
+	// breakpoint halt executes the stopped instruction.
+	Breakpoint  uint16
+	Breakpoints map[uint16]bool // Additional stop addresses, checked before executing
+	Halted      bool
+	bpResume    bool   // Set when Halted by a breakpoint at bpPC
+	bpPC        uint16 // Address of the last breakpoint hit
 
 	// Write tracking
 	LastWriteAddr uint16
@@ -31,6 +37,35 @@ type CPU6502 struct {
 	// Memory access callbacks for validation
 	OnRead  func(addr uint16) // Called on memory reads from copy operations
 	OnWrite func(addr uint16) // Called on memory writes to buffers
+
+	// Watchpoints on data accesses made by instructions, including
+	// indirect pointer fetches and both halves of read-modify-write ops
+	// (not opcode/operand fetches or stack operations)
+	ReadWatch  func(addr uint16)
+	WriteWatch func(addr uint16, val byte)
+
+	// Writes outside the range given to WatchRange
+	StrayWrites []uint16
+
+	// Instruction trace, called before each opcode executes
+	Trace func(pc uint16, op byte, a, x, y, p, sp byte)
+
+	// Set when SP wraps below $00 (overflow) or above $FF (underflow)
+	StackOverflow  bool
+	StackUnderflow bool
+
+	// Execution profile, PC -> times executed (nil = disabled)
+	ExecCount  map[uint16]uint64
+	execCycles map[uint16]uint64 // PC -> base cycles of the opcodes that ran there
+
+	// Self-modifying code detection, addr -> writes into the bytes of
+	// an already executed instruction (nil = disabled, see ResetCodeWrites)
+	CodeWrites map[uint16]int
+	executed   [1024]uint64 // Bitmap of executed opcode and operand bytes
+
+	// Pages written since the last Snapshot, one bit per 256-byte page
+	dirty [4]uint64
+	snap  *CPUSnapshot
 }
 
 // Status flag bits
@@ -45,6 +80,40 @@ const (
 	FlagN byte = 1 << 7 // Negative
 )
 
+// IllegalOpcodeError is returned by Step when it meets an opcode the
+// emulator does not implement. PC is the address of the opcode byte.
+type IllegalOpcodeError struct {
+	Op byte
+	PC uint16
+}
+
+func (e *IllegalOpcodeError) Error() string {
+	return fmt.Sprintf("unknown opcode $%02X at $%04X", e.Op, e.PC)
+}
+
+// cycleTable holds the base NMOS 6502 cycle count for each opcode.
+// Page-crossing and taken-branch penalties are added by Step.
+// Includes the stable undocumented opcodes; unimplemented opcodes are 0.
+var cycleTable = [256]int{
+	//       0  1  2  3  4  5  6  7  8  9  A  B  C  D  E  F
+	/* 0 */ 7, 6, 0, 8, 0, 3, 5, 5, 3, 2, 2, 0, 0, 4, 6, 6,
+	/* 1 */ 2, 5, 0, 8, 0, 4, 6, 6, 2, 4, 0, 7, 0, 4, 7, 7,
+	/* 2 */ 6, 6, 0, 8, 3, 3, 5, 5, 4, 2, 2, 0, 4, 4, 6, 6,
+	/* 3 */ 2, 5, 0, 8, 0, 4, 6, 6, 2, 4, 0, 7, 0, 4, 7, 7,
+	/* 4 */ 6, 6, 0, 8, 0, 3, 5, 5, 3, 2, 2, 0, 3, 4, 6, 6,
+	/* 5 */ 2, 5, 0, 8, 0, 4, 6, 6, 2, 4, 0, 7, 0, 4, 7, 7,
+	/* 6 */ 6, 6, 0, 8, 0, 3, 5, 5, 4, 2, 2, 0, 5, 4, 6, 6,
+	/* 7 */ 2, 5, 0, 8, 0, 4, 6, 6, 2, 4, 0, 7, 0, 4, 7, 7,
+	/* 8 */ 0, 6, 0, 6, 3, 3, 3, 3, 2, 0, 2, 0, 4, 4, 4, 4,
+	/* 9 */ 2, 6, 0, 0, 4, 4, 4, 4, 2, 5, 2, 0, 0, 5, 0, 0,
+	/* A */ 2, 6, 2, 6, 3, 3, 3, 3, 2, 2, 2, 0, 4, 4, 4, 4,
+	/* B */ 2, 5, 0, 5, 4, 4, 4, 4, 2, 4, 2, 0, 4, 4, 4, 4,
+	/* C */ 2, 6, 0, 8, 3, 3, 5, 5, 2, 2, 2, 0, 4, 4, 6, 6,
+	/* D */ 2, 5, 0, 8, 0, 4, 6, 6, 2, 4, 0, 7, 0, 4, 7, 7,
+	/* E */ 2, 6, 0, 8, 3, 3, 5, 5, 2, 2, 2, 0, 4, 4, 6, 6,
+	/* F */ 2, 5, 0, 8, 0, 4, 6, 6, 2, 4, 0, 7, 0, 4, 7, 7,
+}
+
 func NewCPU6502() *CPU6502 {
 	cpu := &CPU6502{
 		SP:           0xFF,
@@ -57,6 +126,55 @@ func NewCPU6502() *CPU6502 {
 	return cpu
 }
 
+// Reset clears the registers and loads PC from the reset vector at $FFFC,
+// leaving memory intact. SP ends at $FD as on real hardware, where the
+// reset sequence performs three suppressed stack pushes.
+func (c *CPU6502) Reset() {
+	c.A, c.X, c.Y = 0, 0, 0
+	c.SP = 0xFD
+	c.P = FlagU | FlagI
+	c.PC = c.read16(0xFFFC)
+	c.Halted = false
+	c.StackOverflow, c.StackUnderflow = false, false
+	c.bpResume = false
+}
+
+// read fetches an instruction operand, firing ReadWatch
+func (c *CPU6502) read(addr uint16) byte {
+	if c.ReadWatch != nil {
+		c.ReadWatch(addr)
+	}
+	return c.mem[addr]
+}
+
+// write stores an instruction result, firing WriteWatch
+func (c *CPU6502) write(addr uint16, v byte) {
+	c.mem[addr] = v
+	c.markDirty(addr)
+	if c.CodeWrites != nil && c.executed[addr>>6]&(1<<(addr&63)) != 0 {
+		c.CodeWrites[addr]++
+	}
+	if c.WriteWatch != nil {
+		c.WriteWatch(addr, v)
+	}
+}
+
+// WatchRange installs a WriteWatch that appends every write outside
+// lo..hi (inclusive) to StrayWrites, chaining to any WriteWatch
+// already installed
+func (c *CPU6502) WatchRange(lo, hi uint16) {
+	c.StrayWrites = nil
+	prev := c.WriteWatch
+	c.WriteWatch = func(addr uint16, val byte) {
+		if prev != nil {
+			prev(addr, val)
+		}
+		if addr < lo || addr > hi {
+			c.StrayWrites = append(c.StrayWrites, addr)
+		}
+	}
+}
+
 // trackWrite tracks writes to the monitored memory range
 func (c *CPU6502) trackWrite(addr uint16) {
 	if addr >= 0x1000 && addr < 0xD000 {
@@ -93,19 +211,34 @@ func (c *CPU6502) setN(v byte) {
 	}
 }
 
+func (c *CPU6502) setC(carry bool) {
+	if carry {
+		c.P |= FlagC
+	} else {
+		c.P &^= FlagC
+	}
+}
+
 func (c *CPU6502) setNZ(v byte) {
 	c.setN(v)
 	c.setZ(v)
 }
 
 func (c *CPU6502) push(v byte) {
-	c.Mem[0x100+uint16(c.SP)] = v
+	c.mem[0x100+uint16(c.SP)] = v
+	c.dirty[0] |= 1 << 1
+	if c.SP == 0x00 {
+		c.StackOverflow = true
+	}
 	c.SP--
 }
 
 func (c *CPU6502) pop() byte {
+	if c.SP == 0xFF {
+		c.StackUnderflow = true
+	}
 	c.SP++
-	return c.Mem[0x100+uint16(c.SP)]
+	return c.mem[0x100+uint16(c.SP)]
 }
 
 func (c *CPU6502) push16(v uint16) {
@@ -120,73 +253,120 @@ func (c *CPU6502) pop16() uint16 {
 }
 
 func (c *CPU6502) read16(addr uint16) uint16 {
-	lo := uint16(c.Mem[addr])
-	hi := uint16(c.Mem[addr+1])
+	lo := uint16(c.mem[addr])
+	hi := uint16(c.mem[addr+1])
 	return hi<<8 | lo
 }
 
 // Addressing mode helpers
 func (c *CPU6502) addrZP() uint16 {
-	addr := uint16(c.Mem[c.PC])
+	addr := uint16(c.mem[c.PC])
 	c.PC++
 	return addr
 }
 
 func (c *CPU6502) addrZPX() uint16 {
-	addr := uint16(c.Mem[c.PC] + c.X)
+	addr := uint16(c.mem[c.PC] + c.X)
 	c.PC++
 	return addr
 }
 
 func (c *CPU6502) addrZPY() uint16 {
-	addr := uint16(c.Mem[c.PC] + c.Y)
+	addr := uint16(c.mem[c.PC] + c.Y)
 	c.PC++
 	return addr
 }
 
 func (c *CPU6502) addrAbs() uint16 {
-	lo := uint16(c.Mem[c.PC])
-	hi := uint16(c.Mem[c.PC+1])
+	lo := uint16(c.mem[c.PC])
+	hi := uint16(c.mem[c.PC+1])
 	c.PC += 2
 	return hi<<8 | lo
 }
 
-func (c *CPU6502) addrAbsX() uint16 {
-	lo := uint16(c.Mem[c.PC])
-	hi := uint16(c.Mem[c.PC+1])
+func (c *CPU6502) addrAbsX() (uint16, bool) {
+	lo := uint16(c.mem[c.PC])
+	hi := uint16(c.mem[c.PC+1])
 	c.PC += 2
-	return (hi<<8 | lo) + uint16(c.X)
+	base := hi<<8 | lo
+	addr := base + uint16(c.X)
+	return addr, addr&0xFF00 != base&0xFF00
 }
 
-func (c *CPU6502) addrAbsY() uint16 {
-	lo := uint16(c.Mem[c.PC])
-	hi := uint16(c.Mem[c.PC+1])
+func (c *CPU6502) addrAbsY() (uint16, bool) {
+	lo := uint16(c.mem[c.PC])
+	hi := uint16(c.mem[c.PC+1])
 	c.PC += 2
-	return (hi<<8 | lo) + uint16(c.Y)
+	base := hi<<8 | lo
+	addr := base + uint16(c.Y)
+	return addr, addr&0xFF00 != base&0xFF00
 }
 
 func (c *CPU6502) addrIndX() uint16 {
-	zp := c.Mem[c.PC] + c.X
+	zp := c.mem[c.PC] + c.X
 	c.PC++
-	lo := uint16(c.Mem[zp])
-	hi := uint16(c.Mem[zp+1])
+	lo := uint16(c.read(uint16(zp)))
+	hi := uint16(c.read(uint16(zp + 1)))
 	return hi<<8 | lo
 }
 
-func (c *CPU6502) addrIndY() uint16 {
-	zp := c.Mem[c.PC]
+func (c *CPU6502) addrIndY() (uint16, bool) {
+	zp := c.mem[c.PC]
 	c.PC++
-	lo := uint16(c.Mem[zp])
-	hi := uint16(c.Mem[zp+1])
-	return (hi<<8 | lo) + uint16(c.Y)
+	lo := uint16(c.read(uint16(zp)))
+	hi := uint16(c.read(uint16(zp + 1)))
+	base := hi<<8 | lo
+	addr := base + uint16(c.Y)
+	return addr, addr&0xFF00 != base&0xFF00
 }
 
+// addrIllegalRMW resolves the operand of the undocumented read-modify-write
+// opcodes (SLO/RLA/SRE/RRA/DCP/ISC), whose addressing mode is encoded in
+// the low five opcode bits. The page-crossing cycle is always paid.
+func (c *CPU6502) addrIllegalRMW(opcode byte) uint16 {
+	switch opcode & 0x1F {
+	case 0x03: // (zp,X)
+		return c.addrIndX()
+	case 0x07: // zp
+		return c.addrZP()
+	case 0x0F: // abs
+		return c.addrAbs()
+	case 0x13: // (zp),Y
+		addr, _ := c.addrIndY()
+		return addr
+	case 0x17: // zp,X
+		return c.addrZPX()
+	case 0x1B: // abs,Y
+		addr, _ := c.addrAbsY()
+		return addr
+	default: // 0x1F: abs,X
+		addr, _ := c.addrAbsX()
+		return addr
+	}
+}
+
+// pageCross charges the extra cycle read instructions spend when an
+// indexed address crosses a page. Stores and read-modify-write forms
+// always pay it, so their base cycle counts already include it.
+func (c *CPU6502) pageCross(addr uint16, crossed bool) uint16 {
+	if crossed {
+		c.Cycles++
+	}
+	return addr
+}
+
+// branch costs +1 cycle when taken, +1 more if the target is on a
+// different page than the instruction following the branch
 func (c *CPU6502) branch(cond bool) {
-	offset := int8(c.Mem[c.PC])
+	offset := int8(c.mem[c.PC])
 	c.PC++
 	if cond {
+		next := c.PC
 		c.PC = uint16(int32(c.PC) + int32(offset))
 		c.Cycles++
+		if c.PC&0xFF00 != next&0xFF00 {
+			c.Cycles++
+		}
 	}
 }
 
@@ -200,133 +380,154 @@ func (c *CPU6502) compare(a, b byte) {
 	c.setNZ(byte(result))
 }
 
-// Step executes one instruction
+// Step executes one instruction. Unimplemented opcodes return an
+// *IllegalOpcodeError and leave PC just past the offending byte.
 func (c *CPU6502) Step() error {
-	if c.PC == c.Breakpoint {
+	// The first Step after a breakpoint halt runs the stopped instruction
+	resume := c.bpResume && c.PC == c.bpPC
+	c.bpResume = false
+	if !resume && (c.PC == c.Breakpoint || c.Breakpoints[c.PC]) {
 		c.Halted = true
+		c.bpResume, c.bpPC = true, c.PC
 		return nil
 	}
 
-	opcode := c.Mem[c.PC]
+	opcode := c.mem[c.PC]
+	if c.Trace != nil {
+		c.Trace(c.PC, opcode, c.A, c.X, c.Y, c.P, c.SP)
+	}
+	if c.ExecCount != nil {
+		if len(c.ExecCount) == 0 || c.execCycles == nil {
+			c.execCycles = make(map[uint16]uint64)
+		}
+		c.ExecCount[c.PC]++
+		c.execCycles[c.PC] += uint64(cycleTable[opcode])
+	}
+	if c.CodeWrites != nil {
+		for i := 0; i < instrSize(opcode); i++ {
+			a := c.PC + uint16(i)
+			c.executed[a>>6] |= 1 << (a & 63)
+		}
+	}
 	c.PC++
-	c.Cycles++
+	c.Cycles += uint64(cycleTable[opcode])
 
 	switch opcode {
 	// LDA
 	case 0xA9: // LDA #imm
-		c.A = c.Mem[c.PC]
+		c.A = c.mem[c.PC]
 		c.PC++
 		c.setNZ(c.A)
 	case 0xA5: // LDA zp
-		c.A = c.Mem[c.addrZP()]
+		c.A = c.read(c.addrZP())
 		c.setNZ(c.A)
 	case 0xB5: // LDA zp,X
-		c.A = c.Mem[c.addrZPX()]
+		c.A = c.read(c.addrZPX())
 		c.setNZ(c.A)
 	case 0xAD: // LDA abs
-		c.A = c.Mem[c.addrAbs()]
+		c.A = c.read(c.addrAbs())
 		c.setNZ(c.A)
 	case 0xBD: // LDA abs,X
-		c.A = c.Mem[c.addrAbsX()]
+		c.A = c.read(c.pageCross(c.addrAbsX()))
 		c.setNZ(c.A)
 	case 0xB9: // LDA abs,Y
-		c.A = c.Mem[c.addrAbsY()]
+		c.A = c.read(c.pageCross(c.addrAbsY()))
 		c.setNZ(c.A)
 	case 0xA1: // LDA (zp,X)
-		c.A = c.Mem[c.addrIndX()]
+		c.A = c.read(c.addrIndX())
 		c.setNZ(c.A)
 	case 0xB1: // LDA (zp),Y
-		zpAddr := c.Mem[c.PC] // Get zero page address before addrIndY increments PC
-		addr := c.addrIndY()
+		zpAddr := c.mem[c.PC] // Get zero page address before addrIndY increments PC
+		addr := c.pageCross(c.addrIndY())
 		// Only track reads from zp_ref ($09) - copy operations
 		// Don't track reads from zp_src ($02) - compressed stream reads
 		if zpAddr == 0x09 {
 			c.trackRead(addr)
 		}
-		c.A = c.Mem[addr]
+		c.A = c.read(addr)
 		c.setNZ(c.A)
 
 	// LDX
 	case 0xA2: // LDX #imm
-		c.X = c.Mem[c.PC]
+		c.X = c.mem[c.PC]
 		c.PC++
 		c.setNZ(c.X)
 	case 0xA6: // LDX zp
-		c.X = c.Mem[c.addrZP()]
+		c.X = c.read(c.addrZP())
 		c.setNZ(c.X)
 	case 0xB6: // LDX zp,Y
-		c.X = c.Mem[c.addrZPY()]
+		c.X = c.read(c.addrZPY())
 		c.setNZ(c.X)
 	case 0xAE: // LDX abs
-		c.X = c.Mem[c.addrAbs()]
+		c.X = c.read(c.addrAbs())
 		c.setNZ(c.X)
 	case 0xBE: // LDX abs,Y
-		c.X = c.Mem[c.addrAbsY()]
+		c.X = c.read(c.pageCross(c.addrAbsY()))
 		c.setNZ(c.X)
 
 	// LDY
 	case 0xA0: // LDY #imm
-		c.Y = c.Mem[c.PC]
+		c.Y = c.mem[c.PC]
 		c.PC++
 		c.setNZ(c.Y)
 	case 0xA4: // LDY zp
-		c.Y = c.Mem[c.addrZP()]
+		c.Y = c.read(c.addrZP())
 		c.setNZ(c.Y)
 	case 0xB4: // LDY zp,X
-		c.Y = c.Mem[c.addrZPX()]
+		c.Y = c.read(c.addrZPX())
 		c.setNZ(c.Y)
 	case 0xAC: // LDY abs
-		c.Y = c.Mem[c.addrAbs()]
+		c.Y = c.read(c.addrAbs())
 		c.setNZ(c.Y)
 	case 0xBC: // LDY abs,X
-		c.Y = c.Mem[c.addrAbsX()]
+		c.Y = c.read(c.pageCross(c.addrAbsX()))
 		c.setNZ(c.Y)
 
 	// STA
 	case 0x85: // STA zp
 		addr := c.addrZP()
-		c.Mem[addr] = c.A
+		c.write(addr, c.A)
 		c.trackWrite(addr)
 	case 0x95: // STA zp,X
 		addr := c.addrZPX()
-		c.Mem[addr] = c.A
+		c.write(addr, c.A)
 		c.trackWrite(addr)
 	case 0x8D: // STA abs
 		addr := c.addrAbs()
-		c.Mem[addr] = c.A
+		c.write(addr, c.A)
 		c.trackWrite(addr)
 	case 0x9D: // STA abs,X
-		addr := c.addrAbsX()
-		c.Mem[addr] = c.A
+		addr, _ := c.addrAbsX()
+		c.write(addr, c.A)
 		c.trackWrite(addr)
 	case 0x99: // STA abs,Y
-		addr := c.addrAbsY()
-		c.Mem[addr] = c.A
+		addr, _ := c.addrAbsY()
+		c.write(addr, c.A)
 		c.trackWrite(addr)
 	case 0x81: // STA (zp,X)
 		addr := c.addrIndX()
-		c.Mem[addr] = c.A
+		c.write(addr, c.A)
 		c.trackWrite(addr)
 	case 0x91: // STA (zp),Y
-		addr := c.addrIndY()
-		c.Mem[addr] = c.A
+		addr, _ := c.addrIndY()
+		c.write(addr, c.A)
 		c.trackWrite(addr)
 
 	// STX
 	case 0x86: // STX zp
-		c.Mem[c.addrZP()] = c.X
+		c.write(c.addrZP(), c.X)
 	case 0x96: // STX zp,Y
-		c.Mem[c.addrZPY()] = c.X
+		c.write(c.addrZPY(), c.X)
 	case 0x8E: // STX abs
-		c.Mem[c.addrAbs()] = c.X
+		c.write(c.addrAbs(), c.X)
 
 	// STY
 	case 0x84: // STY zp
-		c.Mem[c.addrZP()] = c.Y
+		c.write(c.addrZP(), c.Y)
 	case 0x94: // STY zp,X
-		c.Mem[c.addrZPX()] = c.Y
+		c.write(c.addrZPX(), c.Y)
 	case 0x8C: // STY abs
-		c.Mem[c.addrAbs()] = c.Y
+		c.write(c.addrAbs(), c.Y)
 
 	// Transfer
 	case 0xAA: // TAX
@@ -361,36 +562,36 @@ func (c *CPU6502) Step() error {
 	// INC/DEC
 	case 0xE6: // INC zp
 		addr := c.addrZP()
-		c.Mem[addr]++
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.read(addr)+1)
+		c.setNZ(c.mem[addr])
 	case 0xF6: // INC zp,X
 		addr := c.addrZPX()
-		c.Mem[addr]++
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.read(addr)+1)
+		c.setNZ(c.mem[addr])
 	case 0xEE: // INC abs
 		addr := c.addrAbs()
-		c.Mem[addr]++
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.read(addr)+1)
+		c.setNZ(c.mem[addr])
 	case 0xFE: // INC abs,X
-		addr := c.addrAbsX()
-		c.Mem[addr]++
-		c.setNZ(c.Mem[addr])
+		addr, _ := c.addrAbsX()
+		c.write(addr, c.read(addr)+1)
+		c.setNZ(c.mem[addr])
 	case 0xC6: // DEC zp
 		addr := c.addrZP()
-		c.Mem[addr]--
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.read(addr)-1)
+		c.setNZ(c.mem[addr])
 	case 0xD6: // DEC zp,X
 		addr := c.addrZPX()
-		c.Mem[addr]--
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.read(addr)-1)
+		c.setNZ(c.mem[addr])
 	case 0xCE: // DEC abs
 		addr := c.addrAbs()
-		c.Mem[addr]--
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.read(addr)-1)
+		c.setNZ(c.mem[addr])
 	case 0xDE: // DEC abs,X
-		addr := c.addrAbsX()
-		c.Mem[addr]--
-		c.setNZ(c.Mem[addr])
+		addr, _ := c.addrAbsX()
+		c.write(addr, c.read(addr)-1)
+		c.setNZ(c.mem[addr])
 	case 0xE8: // INX
 		c.X++
 		c.setNZ(c.X)
@@ -406,83 +607,83 @@ func (c *CPU6502) Step() error {
 
 	// AND
 	case 0x29: // AND #imm
-		c.A &= c.Mem[c.PC]
+		c.A &= c.mem[c.PC]
 		c.PC++
 		c.setNZ(c.A)
 	case 0x25: // AND zp
-		c.A &= c.Mem[c.addrZP()]
+		c.A &= c.read(c.addrZP())
 		c.setNZ(c.A)
 	case 0x35: // AND zp,X
-		c.A &= c.Mem[c.addrZPX()]
+		c.A &= c.read(c.addrZPX())
 		c.setNZ(c.A)
 	case 0x2D: // AND abs
-		c.A &= c.Mem[c.addrAbs()]
+		c.A &= c.read(c.addrAbs())
 		c.setNZ(c.A)
 	case 0x3D: // AND abs,X
-		c.A &= c.Mem[c.addrAbsX()]
+		c.A &= c.read(c.pageCross(c.addrAbsX()))
 		c.setNZ(c.A)
 	case 0x39: // AND abs,Y
-		c.A &= c.Mem[c.addrAbsY()]
+		c.A &= c.read(c.pageCross(c.addrAbsY()))
 		c.setNZ(c.A)
 	case 0x21: // AND (zp,X)
-		c.A &= c.Mem[c.addrIndX()]
+		c.A &= c.read(c.addrIndX())
 		c.setNZ(c.A)
 	case 0x31: // AND (zp),Y
-		c.A &= c.Mem[c.addrIndY()]
+		c.A &= c.read(c.pageCross(c.addrIndY()))
 		c.setNZ(c.A)
 
 	// ORA
 	case 0x09: // ORA #imm
-		c.A |= c.Mem[c.PC]
+		c.A |= c.mem[c.PC]
 		c.PC++
 		c.setNZ(c.A)
 	case 0x05: // ORA zp
-		c.A |= c.Mem[c.addrZP()]
+		c.A |= c.read(c.addrZP())
 		c.setNZ(c.A)
 	case 0x15: // ORA zp,X
-		c.A |= c.Mem[c.addrZPX()]
+		c.A |= c.read(c.addrZPX())
 		c.setNZ(c.A)
 	case 0x0D: // ORA abs
-		c.A |= c.Mem[c.addrAbs()]
+		c.A |= c.read(c.addrAbs())
 		c.setNZ(c.A)
 	case 0x1D: // ORA abs,X
-		c.A |= c.Mem[c.addrAbsX()]
+		c.A |= c.read(c.pageCross(c.addrAbsX()))
 		c.setNZ(c.A)
 	case 0x19: // ORA abs,Y
-		c.A |= c.Mem[c.addrAbsY()]
+		c.A |= c.read(c.pageCross(c.addrAbsY()))
 		c.setNZ(c.A)
 	case 0x01: // ORA (zp,X)
-		c.A |= c.Mem[c.addrIndX()]
+		c.A |= c.read(c.addrIndX())
 		c.setNZ(c.A)
 	case 0x11: // ORA (zp),Y
-		c.A |= c.Mem[c.addrIndY()]
+		c.A |= c.read(c.pageCross(c.addrIndY()))
 		c.setNZ(c.A)
 
 	// EOR
 	case 0x49: // EOR #imm
-		c.A ^= c.Mem[c.PC]
+		c.A ^= c.mem[c.PC]
 		c.PC++
 		c.setNZ(c.A)
 	case 0x45: // EOR zp
-		c.A ^= c.Mem[c.addrZP()]
+		c.A ^= c.read(c.addrZP())
 		c.setNZ(c.A)
 	case 0x55: // EOR zp,X
-		c.A ^= c.Mem[c.addrZPX()]
+		c.A ^= c.read(c.addrZPX())
 		c.setNZ(c.A)
 	case 0x4D: // EOR abs
-		c.A ^= c.Mem[c.addrAbs()]
+		c.A ^= c.read(c.addrAbs())
 		c.setNZ(c.A)
 	case 0x5D: // EOR abs,X
-		c.A ^= c.Mem[c.addrAbsX()]
+		c.A ^= c.read(c.pageCross(c.addrAbsX()))
 		c.setNZ(c.A)
 	case 0x59: // EOR abs,Y
-		c.A ^= c.Mem[c.addrAbsY()]
+		c.A ^= c.read(c.pageCross(c.addrAbsY()))
 		c.setNZ(c.A)
 	case 0x41: // EOR (zp,X)
-		c.A ^= c.Mem[c.addrIndX()]
+		c.A ^= c.read(c.addrIndX())
 		c.setNZ(c.A)
 	case 0x51: // EOR (zp),Y
-		c.A ^= c.Mem[c.addrIndY()]
+		c.A ^= c.read(c.pageCross(c.addrIndY()))
 		c.setNZ(c.A)
 
 	// ASL
@@ -496,40 +697,40 @@ func (c *CPU6502) Step() error {
 		c.setNZ(c.A)
 	case 0x06: // ASL zp
 		addr := c.addrZP()
-		if c.Mem[addr]&0x80 != 0 {
+		if c.read(addr)&0x80 != 0 {
 			c.P |= FlagC
 		} else {
 			c.P &^= FlagC
 		}
-		c.Mem[addr] <<= 1
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.mem[addr]<<1)
+		c.setNZ(c.mem[addr])
 	case 0x16: // ASL zp,X
 		addr := c.addrZPX()
-		if c.Mem[addr]&0x80 != 0 {
+		if c.read(addr)&0x80 != 0 {
 			c.P |= FlagC
 		} else {
 			c.P &^= FlagC
 		}
-		c.Mem[addr] <<= 1
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.mem[addr]<<1)
+		c.setNZ(c.mem[addr])
 	case 0x0E: // ASL abs
 		addr := c.addrAbs()
-		if c.Mem[addr]&0x80 != 0 {
+		if c.read(addr)&0x80 != 0 {
 			c.P |= FlagC
 		} else {
 			c.P &^= FlagC
 		}
-		c.Mem[addr] <<= 1
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.mem[addr]<<1)
+		c.setNZ(c.mem[addr])
 	case 0x1E: // ASL abs,X
-		addr := c.addrAbsX()
-		if c.Mem[addr]&0x80 != 0 {
+		addr, _ := c.addrAbsX()
+		if c.read(addr)&0x80 != 0 {
 			c.P |= FlagC
 		} else {
 			c.P &^= FlagC
 		}
-		c.Mem[addr] <<= 1
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.mem[addr]<<1)
+		c.setNZ(c.mem[addr])
 
 	// LSR
 	case 0x4A: // LSR A
@@ -542,40 +743,40 @@ func (c *CPU6502) Step() error {
 		c.setNZ(c.A)
 	case 0x46: // LSR zp
 		addr := c.addrZP()
-		if c.Mem[addr]&0x01 != 0 {
+		if c.read(addr)&0x01 != 0 {
 			c.P |= FlagC
 		} else {
 			c.P &^= FlagC
 		}
-		c.Mem[addr] >>= 1
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.mem[addr]>>1)
+		c.setNZ(c.mem[addr])
 	case 0x56: // LSR zp,X
 		addr := c.addrZPX()
-		if c.Mem[addr]&0x01 != 0 {
+		if c.read(addr)&0x01 != 0 {
 			c.P |= FlagC
 		} else {
 			c.P &^= FlagC
 		}
-		c.Mem[addr] >>= 1
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.mem[addr]>>1)
+		c.setNZ(c.mem[addr])
 	case 0x4E: // LSR abs
 		addr := c.addrAbs()
-		if c.Mem[addr]&0x01 != 0 {
+		if c.read(addr)&0x01 != 0 {
 			c.P |= FlagC
 		} else {
 			c.P &^= FlagC
 		}
-		c.Mem[addr] >>= 1
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.mem[addr]>>1)
+		c.setNZ(c.mem[addr])
 	case 0x5E: // LSR abs,X
-		addr := c.addrAbsX()
-		if c.Mem[addr]&0x01 != 0 {
+		addr, _ := c.addrAbsX()
+		if c.read(addr)&0x01 != 0 {
 			c.P |= FlagC
 		} else {
 			c.P &^= FlagC
 		}
-		c.Mem[addr] >>= 1
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.mem[addr]>>1)
+		c.setNZ(c.mem[addr])
 
 	// ROL
 	case 0x2A: // ROL A
@@ -590,43 +791,43 @@ func (c *CPU6502) Step() error {
 	case 0x26: // ROL zp
 		addr := c.addrZP()
 		carry := c.P & FlagC
-		if c.Mem[addr]&0x80 != 0 {
+		if c.read(addr)&0x80 != 0 {
 			c.P |= FlagC
 		} else {
 			c.P &^= FlagC
 		}
-		c.Mem[addr] = c.Mem[addr]<<1 | carry
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.mem[addr]<<1|carry)
+		c.setNZ(c.mem[addr])
 	case 0x36: // ROL zp,X
 		addr := c.addrZPX()
 		carry := c.P & FlagC
-		if c.Mem[addr]&0x80 != 0 {
+		if c.read(addr)&0x80 != 0 {
 			c.P |= FlagC
 		} else {
 			c.P &^= FlagC
 		}
-		c.Mem[addr] = c.Mem[addr]<<1 | carry
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.mem[addr]<<1|carry)
+		c.setNZ(c.mem[addr])
 	case 0x2E: // ROL abs
 		addr := c.addrAbs()
 		carry := c.P & FlagC
-		if c.Mem[addr]&0x80 != 0 {
+		if c.read(addr)&0x80 != 0 {
 			c.P |= FlagC
 		} else {
 			c.P &^= FlagC
 		}
-		c.Mem[addr] = c.Mem[addr]<<1 | carry
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.mem[addr]<<1|carry)
+		c.setNZ(c.mem[addr])
 	case 0x3E: // ROL abs,X
-		addr := c.addrAbsX()
+		addr, _ := c.addrAbsX()
 		carry := c.P & FlagC
-		if c.Mem[addr]&0x80 != 0 {
+		if c.read(addr)&0x80 != 0 {
 			c.P |= FlagC
 		} else {
 			c.P &^= FlagC
 		}
-		c.Mem[addr] = c.Mem[addr]<<1 | carry
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.mem[addr]<<1|carry)
+		c.setNZ(c.mem[addr])
 
 	// ROR
 	case 0x6A: // ROR A
@@ -641,126 +842,126 @@ func (c *CPU6502) Step() error {
 	case 0x66: // ROR zp
 		addr := c.addrZP()
 		carry := c.P & FlagC
-		if c.Mem[addr]&0x01 != 0 {
+		if c.read(addr)&0x01 != 0 {
 			c.P |= FlagC
 		} else {
 			c.P &^= FlagC
 		}
-		c.Mem[addr] = c.Mem[addr]>>1 | carry<<7
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.mem[addr]>>1|carry<<7)
+		c.setNZ(c.mem[addr])
 	case 0x76: // ROR zp,X
 		addr := c.addrZPX()
 		carry := c.P & FlagC
-		if c.Mem[addr]&0x01 != 0 {
+		if c.read(addr)&0x01 != 0 {
 			c.P |= FlagC
 		} else {
 			c.P &^= FlagC
 		}
-		c.Mem[addr] = c.Mem[addr]>>1 | carry<<7
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.mem[addr]>>1|carry<<7)
+		c.setNZ(c.mem[addr])
 	case 0x6E: // ROR abs
 		addr := c.addrAbs()
 		carry := c.P & FlagC
-		if c.Mem[addr]&0x01 != 0 {
+		if c.read(addr)&0x01 != 0 {
 			c.P |= FlagC
 		} else {
 			c.P &^= FlagC
 		}
-		c.Mem[addr] = c.Mem[addr]>>1 | carry<<7
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.mem[addr]>>1|carry<<7)
+		c.setNZ(c.mem[addr])
 	case 0x7E: // ROR abs,X
-		addr := c.addrAbsX()
+		addr, _ := c.addrAbsX()
 		carry := c.P & FlagC
-		if c.Mem[addr]&0x01 != 0 {
+		if c.read(addr)&0x01 != 0 {
 			c.P |= FlagC
 		} else {
 			c.P &^= FlagC
 		}
-		c.Mem[addr] = c.Mem[addr]>>1 | carry<<7
-		c.setNZ(c.Mem[addr])
+		c.write(addr, c.mem[addr]>>1|carry<<7)
+		c.setNZ(c.mem[addr])
 
 	// ADC
 	case 0x69: // ADC #imm
-		c.adc(c.Mem[c.PC])
+		c.adc(c.mem[c.PC])
 		c.PC++
 	case 0x65: // ADC zp
-		c.adc(c.Mem[c.addrZP()])
+		c.adc(c.read(c.addrZP()))
 	case 0x75: // ADC zp,X
-		c.adc(c.Mem[c.addrZPX()])
+		c.adc(c.read(c.addrZPX()))
 	case 0x6D: // ADC abs
-		c.adc(c.Mem[c.addrAbs()])
+		c.adc(c.read(c.addrAbs()))
 	case 0x7D: // ADC abs,X
-		c.adc(c.Mem[c.addrAbsX()])
+		c.adc(c.read(c.pageCross(c.addrAbsX())))
 	case 0x79: // ADC abs,Y
-		c.adc(c.Mem[c.addrAbsY()])
+		c.adc(c.read(c.pageCross(c.addrAbsY())))
 	case 0x61: // ADC (zp,X)
-		c.adc(c.Mem[c.addrIndX()])
+		c.adc(c.read(c.addrIndX()))
 	case 0x71: // ADC (zp),Y
-		c.adc(c.Mem[c.addrIndY()])
+		c.adc(c.read(c.pageCross(c.addrIndY())))
 
 	// SBC
 	case 0xE9: // SBC #imm
-		c.sbc(c.Mem[c.PC])
+		c.sbc(c.mem[c.PC])
 		c.PC++
 	case 0xE5: // SBC zp
-		c.sbc(c.Mem[c.addrZP()])
+		c.sbc(c.read(c.addrZP()))
 	case 0xF5: // SBC zp,X
-		c.sbc(c.Mem[c.addrZPX()])
+		c.sbc(c.read(c.addrZPX()))
 	case 0xED: // SBC abs
-		c.sbc(c.Mem[c.addrAbs()])
+		c.sbc(c.read(c.addrAbs()))
 	case 0xFD: // SBC abs,X
-		c.sbc(c.Mem[c.addrAbsX()])
+		c.sbc(c.read(c.pageCross(c.addrAbsX())))
 	case 0xF9: // SBC abs,Y
-		c.sbc(c.Mem[c.addrAbsY()])
+		c.sbc(c.read(c.pageCross(c.addrAbsY())))
 	case 0xE1: // SBC (zp,X)
-		c.sbc(c.Mem[c.addrIndX()])
+		c.sbc(c.read(c.addrIndX()))
 	case 0xF1: // SBC (zp),Y
-		c.sbc(c.Mem[c.addrIndY()])
+		c.sbc(c.read(c.pageCross(c.addrIndY())))
 
 	// CMP
 	case 0xC9: // CMP #imm
-		c.compare(c.A, c.Mem[c.PC])
+		c.compare(c.A, c.mem[c.PC])
 		c.PC++
 	case 0xC5: // CMP zp
-		c.compare(c.A, c.Mem[c.addrZP()])
+		c.compare(c.A, c.read(c.addrZP()))
 	case 0xD5: // CMP zp,X
-		c.compare(c.A, c.Mem[c.addrZPX()])
+		c.compare(c.A, c.read(c.addrZPX()))
 	case 0xCD: // CMP abs
-		c.compare(c.A, c.Mem[c.addrAbs()])
+		c.compare(c.A, c.read(c.addrAbs()))
 	case 0xDD: // CMP abs,X
-		c.compare(c.A, c.Mem[c.addrAbsX()])
+		c.compare(c.A, c.read(c.pageCross(c.addrAbsX())))
 	case 0xD9: // CMP abs,Y
-		c.compare(c.A, c.Mem[c.addrAbsY()])
+		c.compare(c.A, c.read(c.pageCross(c.addrAbsY())))
 	case 0xC1: // CMP (zp,X)
-		c.compare(c.A, c.Mem[c.addrIndX()])
+		c.compare(c.A, c.read(c.addrIndX()))
 	case 0xD1: // CMP (zp),Y
-		c.compare(c.A, c.Mem[c.addrIndY()])
+		c.compare(c.A, c.read(c.pageCross(c.addrIndY())))
 
 	// CPX
 	case 0xE0: // CPX #imm
-		c.compare(c.X, c.Mem[c.PC])
+		c.compare(c.X, c.mem[c.PC])
 		c.PC++
 	case 0xE4: // CPX zp
-		c.compare(c.X, c.Mem[c.addrZP()])
+		c.compare(c.X, c.read(c.addrZP()))
 	case 0xEC: // CPX abs
-		c.compare(c.X, c.Mem[c.addrAbs()])
+		c.compare(c.X, c.read(c.addrAbs()))
 
 	// CPY
 	case 0xC0: // CPY #imm
-		c.compare(c.Y, c.Mem[c.PC])
+		c.compare(c.Y, c.mem[c.PC])
 		c.PC++
 	case 0xC4: // CPY zp
-		c.compare(c.Y, c.Mem[c.addrZP()])
+		c.compare(c.Y, c.read(c.addrZP()))
 	case 0xCC: // CPY abs
-		c.compare(c.Y, c.Mem[c.addrAbs()])
+		c.compare(c.Y, c.read(c.addrAbs()))
 
 	// BIT
 	case 0x24: // BIT zp
-		v := c.Mem[c.addrZP()]
+		v := c.read(c.addrZP())
 		c.setZ(c.A & v)
 		c.P = c.P&^(FlagN|FlagV) | (v & (FlagN | FlagV))
 	case 0x2C: // BIT abs
-		v := c.Mem[c.addrAbs()]
+		v := c.read(c.addrAbs())
 		c.setZ(c.A & v)
 		c.P = c.P&^(FlagN|FlagV) | (v & (FlagN | FlagV))
 
@@ -788,8 +989,8 @@ func (c *CPU6502) Step() error {
 	case 0x6C: // JMP (abs)
 		addr := c.addrAbs()
 		// 6502 bug: wraps within page
-		lo := uint16(c.Mem[addr])
-		hi := uint16(c.Mem[(addr&0xFF00)|((addr+1)&0xFF)])
+		lo := uint16(c.read(addr))
+		hi := uint16(c.read((addr & 0xFF00) | ((addr + 1) & 0xFF)))
 		c.PC = hi<<8 | lo
 
 	// JSR/RTS
@@ -802,7 +1003,7 @@ func (c *CPU6502) Step() error {
 
 	// RTI
 	case 0x40: // RTI
-		c.P = c.pop() | FlagU
+		c.P = c.pop()&^FlagB | FlagU
 		c.PC = c.pop16()
 
 	// Flags
@@ -842,14 +1043,91 @@ func (c *CPU6502) Step() error {
 		c.PC = c.read16(0xFFFE)
 		c.Halted = true // Stop on BRK for testing
 
+	// Undocumented opcodes (stable NMOS subset)
+	case 0xA7: // LAX zp
+		c.A = c.read(c.addrZP())
+		c.X = c.A
+		c.setNZ(c.A)
+	case 0xB7: // LAX zp,Y
+		c.A = c.read(c.addrZPY())
+		c.X = c.A
+		c.setNZ(c.A)
+	case 0xAF: // LAX abs
+		c.A = c.read(c.addrAbs())
+		c.X = c.A
+		c.setNZ(c.A)
+	case 0xBF: // LAX abs,Y
+		c.A = c.read(c.pageCross(c.addrAbsY()))
+		c.X = c.A
+		c.setNZ(c.A)
+	case 0xA3: // LAX (zp,X)
+		c.A = c.read(c.addrIndX())
+		c.X = c.A
+		c.setNZ(c.A)
+	case 0xB3: // LAX (zp),Y
+		c.A = c.read(c.pageCross(c.addrIndY()))
+		c.X = c.A
+		c.setNZ(c.A)
+
+	case 0x87: // SAX zp
+		c.write(c.addrZP(), c.A&c.X)
+	case 0x97: // SAX zp,Y
+		c.write(c.addrZPY(), c.A&c.X)
+	case 0x8F: // SAX abs
+		c.write(c.addrAbs(), c.A&c.X)
+	case 0x83: // SAX (zp,X)
+		c.write(c.addrIndX(), c.A&c.X)
+
+	case 0x07, 0x17, 0x0F, 0x1F, 0x1B, 0x03, 0x13: // SLO (ASL + ORA)
+		addr := c.addrIllegalRMW(opcode)
+		c.setC(c.read(addr)&0x80 != 0)
+		c.write(addr, c.mem[addr]<<1)
+		c.A |= c.mem[addr]
+		c.setNZ(c.A)
+	case 0x27, 0x37, 0x2F, 0x3F, 0x3B, 0x23, 0x33: // RLA (ROL + AND)
+		addr := c.addrIllegalRMW(opcode)
+		carry := c.P & FlagC
+		c.setC(c.read(addr)&0x80 != 0)
+		c.write(addr, c.mem[addr]<<1|carry)
+		c.A &= c.mem[addr]
+		c.setNZ(c.A)
+	case 0x47, 0x57, 0x4F, 0x5F, 0x5B, 0x43, 0x53: // SRE (LSR + EOR)
+		addr := c.addrIllegalRMW(opcode)
+		c.setC(c.read(addr)&0x01 != 0)
+		c.write(addr, c.mem[addr]>>1)
+		c.A ^= c.mem[addr]
+		c.setNZ(c.A)
+	case 0x67, 0x77, 0x6F, 0x7F, 0x7B, 0x63, 0x73: // RRA (ROR + ADC)
+		addr := c.addrIllegalRMW(opcode)
+		carry := c.P & FlagC
+		c.setC(c.read(addr)&0x01 != 0)
+		c.write(addr, c.mem[addr]>>1|carry<<7)
+		c.adc(c.mem[addr])
+	case 0xC7, 0xD7, 0xCF, 0xDF, 0xDB, 0xC3, 0xD3: // DCP (DEC + CMP)
+		addr := c.addrIllegalRMW(opcode)
+		c.write(addr, c.read(addr)-1)
+		c.compare(c.A, c.mem[addr])
+	case 0xE7, 0xF7, 0xEF, 0xFF, 0xFB, 0xE3, 0xF3: // ISC (INC + SBC)
+		addr := c.addrIllegalRMW(opcode)
+		c.write(addr, c.read(addr)+1)
+		c.sbc(c.mem[addr])
+
 	default:
-		return fmt.Errorf("unknown opcode $%02X at $%04X", opcode, c.PC-1)
+		return &IllegalOpcodeError{Op: opcode, PC: c.PC - 1}
 	}
 
 	return nil
 }
 
 func (c *CPU6502) adc(v byte) {
+	if c.P&FlagD != 0 {
+		c.adcDecimal(v)
+		return
+	}
+	c.adcBinary(v)
+}
+
+func (c *CPU6502) adcBinary(v byte) {
 	carry := uint16(c.P & FlagC)
 	sum := uint16(c.A) + uint16(v) + carry
 	if sum > 0xFF {
@@ -867,9 +1145,57 @@ func (c *CPU6502) adc(v byte) {
 	c.setNZ(c.A)
 }
 
+// adcDecimal is NMOS BCD addition: Z comes from the binary sum,
+// N and V from the intermediate result before the high nibble is adjusted
+func (c *CPU6502) adcDecimal(v byte) {
+	carry := uint16(c.P & FlagC)
+	c.setZ(byte(uint16(c.A) + uint16(v) + carry))
+
+	lo := uint16(c.A&0x0F) + uint16(v&0x0F) + carry
+	if lo > 0x09 {
+		lo += 0x06
+	}
+	hi := uint16(c.A>>4) + uint16(v>>4)
+	if lo > 0x0F {
+		hi++
+	}
+	c.setN(byte(hi << 4))
+	if (c.A^byte(hi<<4))&^(c.A^v)&0x80 != 0 {
+		c.P |= FlagV
+	} else {
+		c.P &^= FlagV
+	}
+	if hi > 0x09 {
+		hi += 0x06
+	}
+	if hi > 0x0F {
+		c.P |= FlagC
+	} else {
+		c.P &^= FlagC
+	}
+	c.A = byte(hi<<4 | lo&0x0F)
+}
+
 func (c *CPU6502) sbc(v byte) {
-	// SBC is ADC with complement
-	c.adc(^v)
+	if c.P&FlagD == 0 {
+		// SBC is ADC with complement
+		c.adcBinary(^v)
+		return
+	}
+	// NMOS BCD subtraction: all flags come from the binary result
+	a := c.A
+	borrow := int(1 - c.P&FlagC)
+	c.adcBinary(^v)
+	lo := int(a&0x0F) - int(v&0x0F) - borrow
+	hi := int(a>>4) - int(v>>4)
+	if lo < 0 {
+		lo -= 0x06
+		hi--
+	}
+	if hi < 0 {
+		hi -= 0x06
+	}
+	c.A = byte(hi<<4 | lo&0x0F)
 }
 
 // Run executes until halted or breakpoint
@@ -882,9 +1208,146 @@ func (c *CPU6502) Run(maxCycles uint64) error {
 	return nil
 }
 
+// RunUntil executes until PC reaches addr, without executing it.
+// Returns false if the CPU halts or runs past maxCycles first, and
+// the Step error if it faults.
+func (c *CPU6502) RunUntil(addr uint16, maxCycles uint64) (bool, error) {
+	for !c.Halted && c.Cycles < maxCycles {
+		if c.PC == addr {
+			return true, nil
+		}
+		if err := c.Step(); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// palFrameCycles is one PAL C64 frame: 63 cycles x 312 raster lines
+const palFrameCycles = 19656
+
+// interrupt pushes PC and P (B clear) and jumps through vector
+func (c *CPU6502) interrupt(vector uint16) {
+	c.push16(c.PC)
+	c.push(c.P&^FlagB | FlagU)
+	c.P |= FlagI
+	c.PC = c.read16(vector)
+	c.Cycles += 7
+}
+
+// TriggerIRQ enters the IRQ handler at ($FFFE) unless FlagI is set.
+// Returns whether the interrupt was taken.
+func (c *CPU6502) TriggerIRQ() bool {
+	if c.P&FlagI != 0 {
+		return false
+	}
+	c.interrupt(0xFFFE)
+	return true
+}
+
+// TriggerNMI enters the NMI handler at ($FFFA); NMIs cannot be masked
+func (c *CPU6502) TriggerNMI() {
+	c.interrupt(0xFFFA)
+}
+
+// RunFramesIRQ installs vector at $FFFE and fires one IRQ per PAL frame,
+// running whatever code was interrupted for the rest of the frame.
+// The caller sets PC to an idle loop and clears FlagI beforehand; an
+// IRQ found masked at the start of a frame, or a halt before all frames
+// have run, is reported as an error.
+func (c *CPU6502) RunFramesIRQ(vector uint16, frames int) error {
+	c.Poke(0xFFFE, byte(vector))
+	c.Poke(0xFFFF, byte(vector>>8))
+	for f := 0; f < frames; f++ {
+		if !c.TriggerIRQ() {
+			return fmt.Errorf("IRQ masked at frame %d (PC=$%04X)", f, c.PC)
+		}
+		if err := c.Run(c.Cycles + palFrameCycles); err != nil {
+			return err
+		}
+		if c.Halted {
+			return fmt.Errorf("halted at $%04X in frame %d of %d", c.PC, f, frames)
+		}
+	}
+	return nil
+}
+
+// Poke stores v at addr without firing watch hooks
+func (c *CPU6502) Poke(addr uint16, v byte) {
+	c.mem[addr] = v
+	c.markDirty(addr)
+}
+
+// Peek returns the byte at addr without firing watch hooks
+func (c *CPU6502) Peek(addr uint16) byte {
+	return c.mem[addr]
+}
+
+// PeekRange returns a copy of up to n bytes starting at addr,
+// stopping at the top of memory
+func (c *CPU6502) PeekRange(addr uint16, n int) []byte {
+	end := min(int(addr)+n, len(c.mem))
+	return append([]byte(nil), c.mem[addr:end]...)
+}
+
 // LoadAt loads data into memory at the specified address
 func (c *CPU6502) LoadAt(addr uint16, data []byte) {
-	copy(c.Mem[addr:], data)
+	n := copy(c.mem[addr:], data)
+	for a := int(addr) &^ 0xFF; a < int(addr)+n; a += 0x100 {
+		c.markDirty(uint16(a))
+	}
+}
+
+// ResetCodeWrites enables self-modifying code detection with an empty
+// CodeWrites map and forgets which bytes have executed so far
+func (c *CPU6502) ResetCodeWrites() {
+	c.CodeWrites = make(map[uint16]int)
+	c.executed = [1024]uint64{}
+}
+
+// CPUSnapshot holds registers and memory captured by Snapshot
+type CPUSnapshot struct {
+	A, X, Y, SP, P byte
+	PC             uint16
+	Cycles         uint64
+	Mem            [65536]byte
+}
+
+func (c *CPU6502) markDirty(addr uint16) {
+	c.dirty[addr>>14] |= 1 << (addr >> 8 & 63)
+}
+
+// Snapshot captures registers and memory. Restoring the most recent
+// snapshot only copies back pages written since it was taken. Memory is
+// only written through the CPU's own methods, so every write is tracked.
+func (c *CPU6502) Snapshot() *CPUSnapshot {
+	s := &CPUSnapshot{
+		A: c.A, X: c.X, Y: c.Y, SP: c.SP, P: c.P,
+		PC: c.PC, Cycles: c.Cycles, Mem: c.mem,
+	}
+	c.dirty = [4]uint64{}
+	c.snap = s
+	return s
+}
+
+// Restore returns the CPU to the state captured by s and clears Halted
+func (c *CPU6502) Restore(s *CPUSnapshot) {
+	c.A, c.X, c.Y, c.SP, c.P = s.A, s.X, s.Y, s.SP, s.P
+	c.PC, c.Cycles = s.PC, s.Cycles
+	c.Halted = false
+	c.bpResume = false
+	if s != c.snap {
+		c.mem = s.Mem
+		c.snap = s
+	} else {
+		for i, w := range c.dirty {
+			for ; w != 0; w &= w - 1 {
+				page := i<<6 | bits.TrailingZeros64(w)
+				copy(c.mem[page<<8:page<<8+0x100], s.Mem[page<<8:])
+			}
+		}
+	}
+	c.dirty = [4]uint64{}
 }
 
 // DumpZP prints zero page for debugging
@@ -893,16 +1356,17 @@ func (c *CPU6502) DumpZP() {
 	for i := 0; i < 256; i += 16 {
 		fmt.Printf("$%02X: ", i)
 		for j := 0; j < 16; j++ {
-			fmt.Printf("%02X ", c.Mem[i+j])
+			fmt.Printf("%02X ", c.mem[i+j])
 		}
 		fmt.Println()
 	}
 }
 
-// DumpRegs prints registers
+// DumpRegs prints registers and the instruction at PC
 func (c *CPU6502) DumpRegs() {
-	fmt.Printf("A=%02X X=%02X Y=%02X SP=%02X PC=%04X P=%02X [%s]\n",
-		c.A, c.X, c.Y, c.SP, c.PC, c.P, c.flagString())
+	instr, _ := Disassemble(c.mem[:], 0, c.PC)
+	fmt.Printf("A=%02X X=%02X Y=%02X SP=%02X PC=%04X P=%02X [%s]  %s\n",
+		c.A, c.X, c.Y, c.SP, c.PC, c.P, c.flagString(), instr)
 }
 
 func (c *CPU6502) flagString() string {
@@ -915,6 +1379,32 @@ func (c *CPU6502) flagString() string {
 	return string(flags)
 }
 
+// HotSpot is one profiled instruction address
+type HotSpot struct {
+	Addr   uint16
+	Count  uint64
+	Cycles uint64 // Base cycles of the opcodes executed at Addr
+}
+
+// HotSpots returns the n profiled addresses with the highest base
+// cycle contribution (page-crossing and branch penalties excluded)
+func (c *CPU6502) HotSpots(n int) []HotSpot {
+	spots := make([]HotSpot, 0, len(c.ExecCount))
+	for addr, count := range c.ExecCount {
+		spots = append(spots, HotSpot{Addr: addr, Count: count, Cycles: c.execCycles[addr]})
+	}
+	sort.Slice(spots, func(i, j int) bool {
+		if spots[i].Cycles != spots[j].Cycles {
+			return spots[i].Cycles > spots[j].Cycles
+		}
+		return spots[i].Addr < spots[j].Addr
+	})
+	if len(spots) > n {
+		spots = spots[:n]
+	}
+	return spots
+}
+
 // Has100PctRedundantFlagOps returns true if any CLC/SEC are always redundant
 func (c *CPU6502) Has100PctRedundantFlagOps() bool {
 	for pc, total := range c.CLCTotal {
@@ -0,0 +1,618 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newTestCPU loads prog at $0800 and points PC at it
+func newTestCPU(prog ...byte) *CPU6502 {
+	c := NewCPU6502()
+	c.LoadAt(0x0800, prog)
+	c.PC = 0x0800
+	return c
+}
+
+// cycleCase runs prog at $0800 for one instruction with ($20) -> $10F0
+type cycleCase struct {
+	name string
+	prog []byte
+	x, y byte
+	want uint64
+}
+
+func checkCycles(t *testing.T, tests []cycleCase) {
+	t.Helper()
+	for _, tt := range tests {
+		c := newTestCPU(tt.prog...)
+		c.X, c.Y = tt.x, tt.y
+		c.LoadAt(0x20, []byte{0xF0, 0x10})
+		if err := c.Step(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if c.Cycles != tt.want {
+			t.Errorf("%s: %d cycles, want %d", tt.name, c.Cycles, tt.want)
+		}
+	}
+}
+
+func TestCycleCounts(t *testing.T) {
+	checkCycles(t, []cycleCase{
+		{"LDA #imm", []byte{0xA9, 0x01}, 0, 0, 2},
+		{"LDA abs", []byte{0xAD, 0x00, 0x10}, 0, 0, 4},
+		{"LDA abs,X", []byte{0xBD, 0x00, 0x10}, 0x10, 0, 4},
+		{"STA abs,X", []byte{0x9D, 0x00, 0x10}, 0x10, 0, 5},
+		{"INC abs,X", []byte{0xFE, 0x00, 0x10}, 0x10, 0, 7},
+		{"JSR abs", []byte{0x20, 0x00, 0x10}, 0, 0, 6},
+		{"RTS", []byte{0x60}, 0, 0, 6},
+	})
+}
+
+func TestPageCrossCycles(t *testing.T) {
+	checkCycles(t, []cycleCase{
+		{"LDA abs,X cross", []byte{0xBD, 0xF0, 0x10}, 0x10, 0, 5},
+		{"LDA abs,Y cross", []byte{0xB9, 0xF0, 0x10}, 0, 0x10, 5},
+		{"STA abs,X cross", []byte{0x9D, 0xF0, 0x10}, 0x10, 0, 5},
+		{"INC abs,X cross", []byte{0xFE, 0xF0, 0x10}, 0x10, 0, 7},
+		{"LDA (zp),Y", []byte{0xB1, 0x20}, 0, 0x01, 5},
+		{"LDA (zp),Y cross", []byte{0xB1, 0x20}, 0, 0x10, 6},
+		{"STA (zp),Y cross", []byte{0x91, 0x20}, 0, 0x10, 6},
+	})
+}
+
+func TestBranchCycles(t *testing.T) {
+	tests := []struct {
+		name   string
+		pc     uint16
+		offset byte
+		taken  bool
+		wantPC uint16
+		want   uint64
+	}{
+		{"not taken", 0x0800, 0x10, false, 0x0802, 2},
+		{"taken", 0x0800, 0x10, true, 0x0812, 3},
+		{"taken backward", 0x0810, 0xFE, true, 0x0810, 3},
+		{"forward cross", 0x08F0, 0x20, true, 0x0912, 4},
+		{"backward cross", 0x0800, 0x80, true, 0x0782, 4},
+		{"not taken at page end", 0x08FE, 0x80, false, 0x0900, 2},
+	}
+	for _, tt := range tests {
+		c := NewCPU6502()
+		c.LoadAt(tt.pc, []byte{0xD0, tt.offset}) // BNE
+		c.PC = tt.pc
+		if !tt.taken {
+			c.P |= FlagZ
+		}
+		if err := c.Step(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if c.PC != tt.wantPC || c.Cycles != tt.want {
+			t.Errorf("%s: PC=$%04X in %d cycles, want $%04X in %d",
+				tt.name, c.PC, c.Cycles, tt.wantPC, tt.want)
+		}
+	}
+}
+
+func TestDecimalSequence(t *testing.T) {
+	// Each step is one instruction run in decimal mode; A and P carry
+	// over from the previous step.
+	steps := []struct {
+		name  string
+		instr []byte
+		wantA byte
+		wantP byte // N, V, Z, C only
+	}{
+		{"CLC", []byte{0x18}, 0x00, 0},
+		{"LDA #$09", []byte{0xA9, 0x09}, 0x09, 0},
+		{"ADC #$01", []byte{0x69, 0x01}, 0x10, 0},
+		{"ADC #$15", []byte{0x69, 0x15}, 0x25, 0},
+		{"LDA #$99", []byte{0xA9, 0x99}, 0x99, FlagN},
+		// Z comes from the binary sum ($9A), N from the intermediate ($A0)
+		{"ADC #$01", []byte{0x69, 0x01}, 0x00, FlagN | FlagC},
+		// Carry in
+		{"ADC #$00", []byte{0x69, 0x00}, 0x01, 0},
+		{"LDA #$50", []byte{0xA9, 0x50}, 0x50, 0},
+		// V and N from the intermediate $A0
+		{"ADC #$50", []byte{0x69, 0x50}, 0x00, FlagN | FlagV | FlagC},
+		{"SEC", []byte{0x38}, 0x00, FlagN | FlagV | FlagC},
+		{"LDA #$10", []byte{0xA9, 0x10}, 0x10, FlagV | FlagC},
+		{"SBC #$01", []byte{0xE9, 0x01}, 0x09, FlagC},
+		{"SBC #$09", []byte{0xE9, 0x09}, 0x00, FlagZ | FlagC},
+		// Borrow out: flags follow the binary result $FF
+		{"SBC #$01", []byte{0xE9, 0x01}, 0x99, FlagN},
+		// Borrow in
+		{"SBC #$00", []byte{0xE9, 0x00}, 0x98, FlagN | FlagC},
+		{"LDA #$00", []byte{0xA9, 0x00}, 0x00, FlagZ | FlagC},
+		{"SBC #$99", []byte{0xE9, 0x99}, 0x01, 0},
+	}
+
+	c := newTestCPU()
+	c.P |= FlagD
+	const mask = FlagN | FlagV | FlagZ | FlagC
+	for i, s := range steps {
+		c.LoadAt(0x0800, s.instr)
+		c.PC = 0x0800
+		if err := c.Step(); err != nil {
+			t.Fatalf("step %d %s: %v", i, s.name, err)
+		}
+		if c.A != s.wantA || c.P&mask != s.wantP {
+			t.Errorf("step %d %s: A=$%02X P=%s, want A=$%02X NVZC=%08b",
+				i, s.name, c.A, c.flagString(), s.wantA, s.wantP)
+		}
+	}
+}
+
+func TestUndocumentedOpcodes(t *testing.T) {
+	// Every addressing mode below resolves to $0040 with X=$04, Y=$10:
+	// ($20) -> $0040 for (zp,X), ($22) -> $0030 for (zp),Y
+	type mode struct {
+		name    string
+		bits    byte // low five opcode bits for the RMW group
+		operand []byte
+		cycles  uint64
+	}
+	rmwModes := []mode{
+		{"(zp,X)", 0x03, []byte{0x1C}, 8},
+		{"zp", 0x07, []byte{0x40}, 5},
+		{"abs", 0x0F, []byte{0x40, 0x00}, 6},
+		{"(zp),Y", 0x13, []byte{0x22}, 8},
+		{"zp,X", 0x17, []byte{0x3C}, 6},
+		{"abs,Y", 0x1B, []byte{0x30, 0x00}, 7},
+		{"abs,X", 0x1F, []byte{0x3C, 0x00}, 7},
+	}
+
+	type opCase struct {
+		name  string
+		op    byte
+		mode  mode
+		wantA byte
+		wantX byte
+		wantM byte
+		wantP byte
+	}
+
+	// Start: A=$5A, M=$C3, C=1
+	tests := []opCase{
+		{"LAX", 0xA7, mode{"zp", 0, []byte{0x40}, 3}, 0xC3, 0xC3, 0xC3, FlagN | FlagC},
+		{"LAX", 0xB7, mode{"zp,Y", 0, []byte{0x30}, 4}, 0xC3, 0xC3, 0xC3, FlagN | FlagC},
+		{"LAX", 0xAF, mode{"abs", 0, []byte{0x40, 0x00}, 4}, 0xC3, 0xC3, 0xC3, FlagN | FlagC},
+		{"LAX", 0xBF, mode{"abs,Y", 0, []byte{0x30, 0x00}, 4}, 0xC3, 0xC3, 0xC3, FlagN | FlagC},
+		{"LAX", 0xBF, mode{"abs,Y cross", 0, []byte{0xF0, 0xFF}, 5}, 0x00, 0x00, 0xC3, FlagZ | FlagC},
+		{"LAX", 0xA3, mode{"(zp,X)", 0, []byte{0x1C}, 6}, 0xC3, 0xC3, 0xC3, FlagN | FlagC},
+		{"LAX", 0xB3, mode{"(zp),Y", 0, []byte{0x22}, 5}, 0xC3, 0xC3, 0xC3, FlagN | FlagC},
+		{"SAX", 0x87, mode{"zp", 0, []byte{0x40}, 3}, 0x5A, 0x04, 0x00, FlagC},
+		{"SAX", 0x97, mode{"zp,Y", 0, []byte{0x30}, 4}, 0x5A, 0x04, 0x00, FlagC},
+		{"SAX", 0x8F, mode{"abs", 0, []byte{0x40, 0x00}, 4}, 0x5A, 0x04, 0x00, FlagC},
+		{"SAX", 0x83, mode{"(zp,X)", 0, []byte{0x1C}, 6}, 0x5A, 0x04, 0x00, FlagC},
+	}
+	rmw := []struct {
+		name         string
+		base         byte
+		wantA, wantM byte
+		wantP        byte
+	}{
+		{"SLO", 0x00, 0xDE, 0x86, FlagN | FlagC},
+		{"RLA", 0x20, 0x02, 0x87, FlagC},
+		{"SRE", 0x40, 0x3B, 0x61, FlagC},
+		{"RRA", 0x60, 0x3C, 0xE1, FlagC},
+		{"DCP", 0xC0, 0x5A, 0xC2, FlagN},
+		{"ISC", 0xE0, 0x96, 0xC4, FlagN | FlagV},
+	}
+	for _, g := range rmw {
+		for _, m := range rmwModes {
+			tests = append(tests, opCase{g.name, g.base | m.bits, m, g.wantA, 0x04, g.wantM, g.wantP})
+		}
+	}
+
+	for _, tt := range tests {
+		c := newTestCPU(append([]byte{tt.op}, tt.mode.operand...)...)
+		c.A, c.X, c.Y = 0x5A, 0x04, 0x10
+		c.P = FlagU | FlagC
+		c.LoadAt(0x20, []byte{0x40, 0x00, 0x30, 0x00})
+		c.Poke(0x40, 0xC3)
+		if err := c.Step(); err != nil {
+			t.Fatalf("%s %s ($%02X): %v", tt.name, tt.mode.name, tt.op, err)
+		}
+		wantP := FlagU | tt.wantP
+		if c.A != tt.wantA || c.X != tt.wantX || c.Peek(0x40) != tt.wantM || c.P != wantP {
+			t.Errorf("%s %s ($%02X): A=$%02X X=$%02X M=$%02X P=$%02X, want A=$%02X X=$%02X M=$%02X P=$%02X",
+				tt.name, tt.mode.name, tt.op, c.A, c.X, c.Peek(0x40), c.P,
+				tt.wantA, tt.wantX, tt.wantM, wantP)
+		}
+		if c.Cycles != tt.mode.cycles {
+			t.Errorf("%s %s ($%02X): %d cycles, want %d",
+				tt.name, tt.mode.name, tt.op, c.Cycles, tt.mode.cycles)
+		}
+		if want := uint16(0x0801 + len(tt.mode.operand)); c.PC != want {
+			t.Errorf("%s %s ($%02X): PC=$%04X, want $%04X", tt.name, tt.mode.name, tt.op, c.PC, want)
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	c := NewCPU6502()
+	c.LoadAt(0xFFFC, []byte{0x34, 0x12})
+	c.A, c.X, c.Y = 1, 2, 3
+	c.SP = 0x42
+	c.P = FlagU | FlagD | FlagC
+	c.Halted = true
+	c.Reset()
+
+	if c.PC != 0x1234 {
+		t.Errorf("PC=$%04X, want $1234", c.PC)
+	}
+	if c.SP != 0xFD {
+		t.Errorf("SP=$%02X, want $FD", c.SP)
+	}
+	if c.A != 0 || c.X != 0 || c.Y != 0 {
+		t.Errorf("A=$%02X X=$%02X Y=$%02X, want all zero", c.A, c.X, c.Y)
+	}
+	if c.P != FlagU|FlagI {
+		t.Errorf("P=$%02X, want $%02X", c.P, FlagU|FlagI)
+	}
+	if c.Halted {
+		t.Error("still halted after Reset")
+	}
+}
+
+func TestBreakpointResume(t *testing.T) {
+	// $0800: INX ; INX ; INX ; JAM
+	c := newTestCPU(0xE8, 0xE8, 0xE8, 0x02)
+	c.Breakpoint = 0xFFFF
+	c.Breakpoints = map[uint16]bool{0x0801: true}
+
+	if err := c.Run(100); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Halted || c.PC != 0x0801 || c.X != 1 {
+		t.Fatalf("first stop: Halted=%v PC=$%04X X=%d, want halted at $0801 with X=1", c.Halted, c.PC, c.X)
+	}
+
+	// Resuming runs past the breakpoint without deleting it
+	c.Halted = false
+	ok, err := c.RunUntil(0x0803, 100)
+	if !ok || err != nil || c.X != 3 {
+		t.Fatalf("RunUntil = %v, %v with X=%d, want true, nil with X=3", ok, err, c.X)
+	}
+	if !c.Breakpoints[0x0801] {
+		t.Error("breakpoint was removed")
+	}
+
+	// The breakpoint fires again on the next visit
+	c.PC = 0x0800
+	if ok, err := c.RunUntil(0x0803, 100); ok || err != nil || c.PC != 0x0801 {
+		t.Errorf("RunUntil = %v, %v at PC=$%04X, want false, nil at $0801", ok, err, c.PC)
+	}
+}
+
+func TestRunUntilError(t *testing.T) {
+	c := newTestCPU(0xE8, 0x02)
+	c.Breakpoint = 0xFFFF
+	ok, err := c.RunUntil(0x0900, 100)
+	if ok {
+		t.Fatal("RunUntil reached target past a JAM opcode")
+	}
+	if e, isIllegal := err.(*IllegalOpcodeError); !isIllegal || e.Op != 0x02 || e.PC != 0x0801 {
+		t.Errorf("err = %v, want IllegalOpcodeError for $02 at $0801", err)
+	}
+
+	// A cycle timeout is not an error
+	c = newTestCPU(0x4C, 0x00, 0x08) // JMP $0800
+	c.Breakpoint = 0xFFFF
+	if ok, err := c.RunUntil(0x0900, 100); ok || err != nil {
+		t.Errorf("timeout: RunUntil = %v, %v, want false, nil", ok, err)
+	}
+}
+
+func TestRestoreAfterRunFramesIRQ(t *testing.T) {
+	// Idle loop at $0800, IRQ handler at $0900 is a bare RTI
+	c := newTestCPU(0x4C, 0x00, 0x08)
+	c.Poke(0x0900, 0x40)
+	c.Breakpoint = 0xFFFF
+	c.P &^= FlagI
+	s := c.Snapshot()
+
+	if err := c.RunFramesIRQ(0x0900, 2); err != nil {
+		t.Fatal(err)
+	}
+	c.Restore(s)
+	if c.Peek(0xFFFE) != 0 || c.Peek(0xFFFF) != 0 {
+		t.Errorf("IRQ vector $%02X%02X survived Restore", c.Peek(0xFFFF), c.Peek(0xFFFE))
+	}
+}
+
+// benchmarkRestore times a song 1 decompression followed by Restore,
+// either through the dirty-page path or a full 64KB copy. The Restore
+// share is reported separately as restore-ns/op.
+func benchmarkRestore(b *testing.B, full bool) {
+	streamMain, err := os.ReadFile(filepath.Join("..", "..", "generated", "stream_main.bin"))
+	if err != nil {
+		b.Skip(err)
+	}
+	mainStart := 0x10000 - len(streamMain)
+
+	c := NewCPU6502()
+	c.LoadAt(0x0D00, GetDecompressorCode())
+	c.LoadAt(uint16(mainStart), streamMain)
+	c.LoadAt(zpSrcLo, []byte{byte(mainStart), byte(mainStart >> 8), 0x80, 0x00, 0x10})
+	c.LoadAt(0x01FE, []byte{0xFE, 0x0C})
+	c.SP = 0xFD
+	c.PC = 0x0D00
+
+	// Restoring a snapshot other than the latest one takes the full
+	// copy path, so alternate between two identical snapshots
+	s := c.Snapshot()
+	alt := [2]*CPUSnapshot{s, c.Snapshot()}
+
+	var restore time.Duration
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.Run(maxSongCycles); err != nil || !c.Halted {
+			b.Fatalf("song 1 did not finish: %v", err)
+		}
+		start := time.Now()
+		if full {
+			c.Restore(alt[i%2])
+		} else {
+			c.Restore(s)
+		}
+		restore += time.Since(start)
+	}
+	b.ReportMetric(float64(restore.Nanoseconds())/float64(b.N), "restore-ns/op")
+}
+
+func BenchmarkRestore(b *testing.B) {
+	b.Run("dirty", func(b *testing.B) { benchmarkRestore(b, false) })
+	b.Run("full", func(b *testing.B) { benchmarkRestore(b, true) })
+}
+
+func TestRestoreAfterPoke(t *testing.T) {
+	c := NewCPU6502()
+	s := c.Snapshot()
+	c.Poke(0x2000, 0x55)
+	c.LoadAt(0x30FF, []byte{1, 2})
+	c.Restore(s)
+	if got := c.PeekRange(0x2000, 1)[0]; got != 0 {
+		t.Errorf("$2000=$%02X after Restore, want $00", got)
+	}
+	if got := c.PeekRange(0x30FF, 2); got[0] != 0 || got[1] != 0 {
+		t.Errorf("$30FF-$3100=% X after Restore, want 00 00", got)
+	}
+}
+
+func TestStackWrapFlags(t *testing.T) {
+	// PLA with an empty stack, then PHA PHA back past $00
+	c := newTestCPU(0x68, 0x48, 0x48)
+	c.SP = 0xFF
+	for i := 0; i < 3; i++ {
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !c.StackUnderflow || !c.StackOverflow {
+		t.Fatalf("underflow=%v overflow=%v, want both set", c.StackUnderflow, c.StackOverflow)
+	}
+	c.Reset()
+	if c.StackUnderflow || c.StackOverflow {
+		t.Error("Reset did not clear the stack wrap flags")
+	}
+}
+
+func TestInterruptEntry(t *testing.T) {
+	c := NewCPU6502()
+	c.LoadAt(0xFFFA, []byte{0x00, 0x40, 0x00, 0x00, 0x00, 0x50}) // NMI $4000, IRQ $5000
+	c.PC = 0x1234
+	c.SP = 0xFD
+	c.P = FlagU | FlagB | FlagC
+
+	if !c.TriggerIRQ() {
+		t.Fatal("IRQ not taken with FlagI clear")
+	}
+	if c.PC != 0x5000 || c.SP != 0xFA || c.Cycles != 7 {
+		t.Errorf("IRQ: PC=$%04X SP=$%02X cycles=%d, want $5000 $FA 7", c.PC, c.SP, c.Cycles)
+	}
+	if c.Peek(0x01FD) != 0x12 || c.Peek(0x01FC) != 0x34 {
+		t.Errorf("pushed PC $%02X%02X, want $1234", c.Peek(0x01FD), c.Peek(0x01FC))
+	}
+	if got, want := c.Peek(0x01FB), FlagU|FlagC; got != want {
+		t.Errorf("pushed P=$%02X, want $%02X (B clear, U set)", got, want)
+	}
+	if c.P&FlagI == 0 {
+		t.Error("FlagI not set after IRQ entry")
+	}
+
+	// Masked IRQ leaves everything alone
+	if c.TriggerIRQ() {
+		t.Error("IRQ taken with FlagI set")
+	}
+	if c.PC != 0x5000 || c.SP != 0xFA {
+		t.Errorf("masked IRQ moved PC=$%04X SP=$%02X", c.PC, c.SP)
+	}
+
+	// NMI ignores FlagI
+	c.TriggerNMI()
+	if c.PC != 0x4000 || c.SP != 0xF7 {
+		t.Errorf("NMI: PC=$%04X SP=$%02X, want $4000 $F7", c.PC, c.SP)
+	}
+	if c.Peek(0x01FA) != 0x50 || c.Peek(0x01F9) != 0x00 {
+		t.Errorf("NMI pushed PC $%02X%02X, want $5000", c.Peek(0x01FA), c.Peek(0x01F9))
+	}
+}
+
+func TestRunFramesIRQ(t *testing.T) {
+	// Idle loop at $0800; handler at $0900 counts frames in $10 then RTIs
+	idle := func() *CPU6502 {
+		c := newTestCPU(0x4C, 0x00, 0x08)
+		c.LoadAt(0x0900, []byte{0xE6, 0x10, 0x40}) // INC $10 ; RTI
+		c.Breakpoint = 0xFFFF
+		c.P &^= FlagI
+		return c
+	}
+
+	c := idle()
+	if err := c.RunFramesIRQ(0x0900, 5); err != nil {
+		t.Fatal(err)
+	}
+	if c.Peek(0x10) != 5 {
+		t.Errorf("handler ran %d times, want 5", c.Peek(0x10))
+	}
+
+	c = idle()
+	c.P |= FlagI
+	if err := c.RunFramesIRQ(0x0900, 5); err == nil {
+		t.Error("masked IRQ: want error")
+	}
+
+	// Handler hits BRK on the second frame
+	c = idle()
+	c.LoadAt(0x0A00, []byte{0xE6, 0x10, 0xA5, 0x10, 0xC9, 0x02, 0xD0, 0x01, 0x00, 0x40})
+	if err := c.RunFramesIRQ(0x0A00, 5); err == nil {
+		t.Error("halt in frame 2 of 5: want error")
+	}
+	if c.Peek(0x10) != 2 {
+		t.Errorf("handler ran %d times, want 2", c.Peek(0x10))
+	}
+}
+
+func TestTrace(t *testing.T) {
+	type event struct {
+		pc                 uint16
+		op, a, x, y, p, sp byte
+	}
+	var got []event
+	c := newTestCPU(0xA9, 0x05, 0xAA, 0xE8) // LDA #$05 ; TAX ; INX
+	c.Trace = func(pc uint16, op byte, a, x, y, p, sp byte) {
+		got = append(got, event{pc, op, a, x, y, p, sp})
+	}
+	c.Step()
+	c.Step()
+
+	// Registers are reported as they were before the opcode ran
+	want := []event{
+		{0x0800, 0xA9, 0x00, 0x00, 0x00, FlagU | FlagI, 0xFF},
+		{0x0802, 0xAA, 0x05, 0x00, 0x00, FlagU | FlagI, 0xFF},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d trace events, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("event %d: %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// A nil hook is skipped
+	c.Trace = nil
+	if err := c.Step(); err != nil || c.X != 6 || len(got) != 2 {
+		t.Errorf("nil Trace: err=%v X=%d events=%d, want nil 6 2", err, c.X, len(got))
+	}
+}
+
+func TestBreakpointAfterRestore(t *testing.T) {
+	c := newTestCPU(0xE8, 0xE8, 0xE8, 0x02)
+	c.Breakpoint = 0xFFFF
+	c.Breakpoints = map[uint16]bool{0x0801: true}
+	c.Run(100)
+	s := c.Snapshot()
+
+	// Replaying from a snapshot taken at the breakpoint stops there again
+	c.Restore(s)
+	c.Run(100)
+	if !c.Halted || c.PC != 0x0801 || c.X != 1 {
+		t.Errorf("Restore: halted=%v at PC=$%04X X=%d, want halted at $0801 X=1", c.Halted, c.PC, c.X)
+	}
+
+	// Halted on the breakpoint again; Reset must not arm a resume either
+	c.LoadAt(0xFFFC, []byte{0x01, 0x08})
+	c.Reset()
+	c.Run(100)
+	if !c.Halted || c.PC != 0x0801 {
+		t.Errorf("Reset: halted=%v at PC=$%04X, want halted at $0801", c.Halted, c.PC)
+	}
+}
+
+func TestWatchpoints(t *testing.T) {
+	// LDA ($20),Y ; INC $40 ; STA $3000 ; PHA
+	c := newTestCPU(0xB1, 0x20, 0xE6, 0x40, 0x8D, 0x00, 0x30, 0x48)
+	c.LoadAt(0x20, []byte{0xF0, 0x10})
+	var reads, writes []uint16
+	c.ReadWatch = func(addr uint16) {
+		reads = append(reads, addr)
+	}
+	c.WriteWatch = func(addr uint16, val byte) {
+		writes = append(writes, addr)
+	}
+	c.WatchRange(0x0000, 0x00FF)
+	for i := 0; i < 4; i++ {
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Pointer fetch, indirect read, then the read half of INC
+	wantReads := []uint16{0x0020, 0x0021, 0x10F0, 0x0040}
+	if !slices.Equal(reads, wantReads) {
+		t.Errorf("reads %04X, want %04X", reads, wantReads)
+	}
+	// The previous WriteWatch still fires; stack pushes are not watched
+	wantWrites := []uint16{0x0040, 0x3000}
+	if !slices.Equal(writes, wantWrites) {
+		t.Errorf("writes %04X, want %04X", writes, wantWrites)
+	}
+	if !slices.Equal(c.StrayWrites, []uint16{0x3000}) {
+		t.Errorf("StrayWrites %04X, want [3000]", c.StrayWrites)
+	}
+}
+
+func TestHotSpots(t *testing.T) {
+	// LDX #3; loop: INC $40; DEX; BNE loop
+	c := newTestCPU(0xA2, 0x03, 0xE6, 0x40, 0xCA, 0xD0, 0xFB)
+	c.ExecCount = make(map[uint16]uint64)
+	for i := 0; i < 10; i++ {
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Patching the loop afterwards must not change the reported cycles
+	c.Poke(0x0802, 0xEA)
+
+	want := []HotSpot{
+		{Addr: 0x0802, Count: 3, Cycles: 15},
+		{Addr: 0x0804, Count: 3, Cycles: 6}, // Ties ordered by address
+		{Addr: 0x0805, Count: 3, Cycles: 6},
+	}
+	if got := c.HotSpots(3); !slices.Equal(got, want) {
+		t.Errorf("HotSpots(3) = %+v, want %+v", got, want)
+	}
+	if got := c.HotSpots(10); len(got) != 4 || got[3] != (HotSpot{Addr: 0x0800, Count: 1, Cycles: 2}) {
+		t.Errorf("HotSpots(10) = %+v, want 4 spots ending at $0800", got)
+	}
+}
+
+func TestCodeWrites(t *testing.T) {
+	// LDA #$00; STA $0801; STA $3000
+	c := newTestCPU(0xA9, 0x00, 0x8D, 0x01, 0x08, 0x8D, 0x00, 0x30)
+	c.ResetCodeWrites()
+	for i := 0; i < 3; i++ {
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only the write into the executed LDA operand counts
+	if len(c.CodeWrites) != 1 || c.CodeWrites[0x0801] != 1 {
+		t.Errorf("CodeWrites = %v, want map[2049:1]", c.CodeWrites)
+	}
+
+	// After a reset only the re-executed STA is marked
+	c.ResetCodeWrites()
+	c.PC = 0x0802
+	if err := c.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.CodeWrites) != 0 {
+		t.Errorf("CodeWrites after ResetCodeWrites = %v, want empty", c.CodeWrites)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 )
 
 // MemoryValidator tracks which memory regions are valid for reading
@@ -192,6 +193,9 @@ func (v *MemoryValidator) Violations() []string {
 	return v.violations
 }
 
+// maxSongCycles bounds a single decompressor call (real 6502 cycles).
+const maxSongCycles = 10000000
+
 func testDecompressor() error {
 	fmt.Println("6502 Decompressor Test")
 	fmt.Println("======================")
@@ -235,16 +239,18 @@ func testDecompressor() error {
 		mainStart, 0xFFFF, tailAddr, tailAddr+len(streamTail)-1)
 
 	cpu := NewCPU6502()
+	cpu.ExecCount = make(map[uint16]uint64)
+	cpu.ResetCodeWrites()
 	cpu.LoadAt(0x0D00, decompCode)
 
 	// Load streams into memory
 	cpu.LoadAt(uint16(mainStart), streamMain)
 	cpu.LoadAt(tailAddr, streamTail)
 
-	cpu.Mem[zpSrcLo] = byte(mainStart)
-	cpu.Mem[zpSrcHi] = byte(mainStart >> 8)
-	cpu.Mem[zpBitBuf] = 0x80
-	cpu.Mem[0x0CFF] = 0x00
+	cpu.Poke(zpSrcLo, byte(mainStart))
+	cpu.Poke(zpSrcHi, byte(mainStart>>8))
+	cpu.Poke(zpBitBuf, 0x80)
+	cpu.Poke(0x0CFF, 0x00)
 
 	// Set up memory validator
 	validator := NewMemoryValidator()
@@ -259,6 +265,17 @@ func testDecompressor() error {
 	var totalCycles uint64
 	var totalViolations []string
 
+	// stackWrapped reports and clears a stack wrap from the last call
+	stackWrapped := func(label string) bool {
+		if !cpu.StackOverflow && !cpu.StackUnderflow {
+			return false
+		}
+		fmt.Printf("%s: STACK WRAP (overflow=%v underflow=%v)\n",
+			label, cpu.StackOverflow, cpu.StackUnderflow)
+		cpu.StackOverflow, cpu.StackUnderflow = false, false
+		return true
+	}
+
 	// Decompress songs 1-8 from main stream
 	for song := 1; song <= 8; song++ {
 		target := songs[song]
@@ -272,17 +289,20 @@ func testDecompressor() error {
 		} else {
 			dstAddr = 0x7000
 		}
-		cpu.Mem[zpOutLo] = byte(dstAddr)
-		cpu.Mem[zpOutHi] = byte(dstAddr >> 8)
+		cpu.Poke(zpOutLo, byte(dstAddr))
+		cpu.Poke(zpOutHi, byte(dstAddr>>8))
 
-		cpu.Mem[0x01FF] = 0x0C
-		cpu.Mem[0x01FE] = 0xFE
+		cpu.Poke(0x01FF, 0x0C)
+		cpu.Poke(0x01FE, 0xFE)
 		cpu.SP = 0xFD
 		cpu.PC = 0x0D00
 		cpu.Halted = false
 		cpu.Cycles = 0
 
-		err := cpu.Run(2000000)
+		err := cpu.Run(maxSongCycles)
+		if stackWrapped(fmt.Sprintf("Song %d", song)) {
+			allPassed = false
+		}
 		if err != nil {
 			fmt.Printf("Song %d: RUNTIME ERROR: %v\n", song, err)
 			allPassed = false
@@ -299,9 +319,9 @@ func testDecompressor() error {
 			totalViolations = append(totalViolations, validator.Violations()...)
 		}
 
-		output := cpu.Mem[dstAddr : dstAddr+uint16(len(target))]
+		output := cpu.PeekRange(dstAddr, len(target))
 		if bytes.Equal(output, target) {
-			srcPos := uint16(cpu.Mem[zpSrcLo]) | uint16(cpu.Mem[zpSrcHi])<<8
+			srcPos := uint16(cpu.Peek(zpSrcLo)) | uint16(cpu.Peek(zpSrcHi))<<8
 			fmt.Printf("Song %d: PASS (%d bytes, %d cycles) [src=$%04X]\n",
 				song, len(target), cpu.Cycles, srcPos)
 			totalCycles += cpu.Cycles
@@ -325,18 +345,21 @@ func testDecompressor() error {
 	// Initialize validator for song 9
 	validator.InitForSong(9, songs)
 
-	cpu.Mem[zpOutLo] = 0x00
-	cpu.Mem[zpOutHi] = 0x10 // $1000
+	cpu.Poke(zpOutLo, 0x00)
+	cpu.Poke(zpOutHi, 0x10) // $1000
 
-	cpu.Mem[0x01FF] = 0x0C
-	cpu.Mem[0x01FE] = 0xFE
+	cpu.Poke(0x01FF, 0x0C)
+	cpu.Poke(0x01FE, 0xFE)
 	cpu.SP = 0xFD
 	cpu.PC = 0x0D00
 	cpu.Halted = false
 	cpu.Cycles = 0
 
 	// Run until terminator in main stream
-	err = cpu.Run(2000000)
+	err = cpu.Run(maxSongCycles)
+	if stackWrapped("Song 9 (main)") {
+		allPassed = false
+	}
 	if err != nil {
 		fmt.Printf("Song 9 (main): RUNTIME ERROR: %v\n", err)
 		allPassed = false
@@ -347,13 +370,13 @@ func testDecompressor() error {
 		mainCycles := cpu.Cycles
 
 		// Check how much of S9 was decompressed
-		outPos := uint16(cpu.Mem[zpOutLo]) | uint16(cpu.Mem[zpOutHi])<<8
+		outPos := uint16(cpu.Peek(zpOutLo)) | uint16(cpu.Peek(zpOutHi))<<8
 		partialLen := int(outPos - 0x1000)
 
 		// Verify partial output matches
 		partialMatch := true
 		for i := 0; i < partialLen && i < len(target9); i++ {
-			if cpu.Mem[0x1000+uint16(i)] != target9[i] {
+			if cpu.Peek(0x1000+uint16(i)) != target9[i] {
 				fmt.Printf("Song 9 (main): MISMATCH at offset %d\n", i)
 				partialMatch = false
 				allPassed = false
@@ -363,18 +386,21 @@ func testDecompressor() error {
 
 		if partialMatch {
 			// Continue from tail stream
-			cpu.Mem[zpSrcLo] = byte(tailAddr & 0xFF)
-			cpu.Mem[zpSrcHi] = byte(tailAddr >> 8)
-			cpu.Mem[zpBitBuf] = 0x80
+			cpu.Poke(zpSrcLo, byte(tailAddr&0xFF))
+			cpu.Poke(zpSrcHi, byte(tailAddr>>8))
+			cpu.Poke(zpBitBuf, 0x80)
 
-			cpu.Mem[0x01FF] = 0x0C
-			cpu.Mem[0x01FE] = 0xFE
+			cpu.Poke(0x01FF, 0x0C)
+			cpu.Poke(0x01FE, 0xFE)
 			cpu.SP = 0xFD
 			cpu.PC = 0x0D00
 			cpu.Halted = false
 			cpu.Cycles = 0
 
-			err = cpu.Run(2000000)
+			err = cpu.Run(maxSongCycles)
+			if stackWrapped("Song 9 (tail)") {
+				allPassed = false
+			}
 			if err != nil {
 				fmt.Printf("Song 9 (tail): RUNTIME ERROR: %v\n", err)
 				allPassed = false
@@ -383,7 +409,7 @@ func testDecompressor() error {
 				allPassed = false
 			} else {
 				// Verify complete S9
-				output9 := cpu.Mem[0x1000 : 0x1000+uint16(len(target9))]
+				output9 := cpu.PeekRange(0x1000, len(target9))
 				if bytes.Equal(output9, target9) {
 					s9Cycles := mainCycles + cpu.Cycles
 					fmt.Printf("Song 9: PASS (%d bytes, %d cycles) [main=%d + tail=%d]\n",
@@ -405,6 +431,20 @@ func testDecompressor() error {
 		}
 	}
 
+	// The decompressor is not meant to patch itself
+	if len(cpu.CodeWrites) > 0 {
+		addrs := make([]int, 0, len(cpu.CodeWrites))
+		for addr := range cpu.CodeWrites {
+			addrs = append(addrs, int(addr))
+		}
+		sort.Ints(addrs)
+		fmt.Printf("\nSelf-modifying code: %d patched addresses\n", len(addrs))
+		for _, addr := range addrs {
+			fmt.Printf("  $%04X written %d times\n", addr, cpu.CodeWrites[uint16(addr)])
+		}
+		allPassed = false
+	}
+
 	// Check for memory access violations from song 9
 	if validator.HasViolations() {
 		totalViolations = append(totalViolations, validator.Violations()...)
@@ -412,6 +452,14 @@ func testDecompressor() error {
 
 	fmt.Printf("\nTotal cycles: %d\n", totalCycles)
 
+	// Report where the decompressor spends its time
+	fmt.Println("\nHot spots (top 20 by base cycles):")
+	for _, h := range cpu.HotSpots(20) {
+		instr, _ := Disassemble(cpu.PeekRange(h.Addr, 3), h.Addr, h.Addr)
+		fmt.Printf("  $%04X  %-14s %9d x  %10d cycles (%5.2f%%)\n",
+			h.Addr, instr, h.Count, h.Cycles, 100*float64(h.Cycles)/float64(totalCycles))
+	}
+
 	// Report memory access violations
 	if len(totalViolations) > 0 {
 		fmt.Printf("\nMemory access violations: %d\n", len(totalViolations))